@@ -18,24 +18,53 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/x509"
+	"embed"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"text/template"
 	"time"
 
 	"github.com/alecthomas/kingpin"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"gopkg.in/yaml.v3"
 
+	"github.com/blakesmith/ar"
 	"github.com/goreleaser/nfpm/v2"
 	_ "github.com/goreleaser/nfpm/v2/apk"
+	_ "github.com/goreleaser/nfpm/v2/arch"
 	_ "github.com/goreleaser/nfpm/v2/deb"
+	"github.com/goreleaser/nfpm/v2/files"
+	_ "github.com/goreleaser/nfpm/v2/ipk"
 	_ "github.com/goreleaser/nfpm/v2/rpm"
 )
 
@@ -60,55 +89,376 @@ var (
 	packager = app.Flag("packager", "Select packager implementation to use, defaults to: `deb,rpm,apk`").
 			Default("deb,rpm,apk").
 			Short('p').
-			Enum("deb", "rpm", "apk", "deb,rpm,apk")
+			Enum("deb", "rpm", "apk", "archlinux", "ipk", "deb,rpm,apk")
 	releaseDir = app.Flag("releaseDir", "Release directory (that contains os-arch specific dirs) to pick up binaries to package, defaults to `appName+\"-release\"`").
 			Short('d').String()
+	jsonLayout = app.Flag("json-layout", "Layout of the generated downloads JSON, `platform` (platform -> arch) or `arch` (arch -> platform)").
+			Default("platform").
+			Enum("platform", "arch")
+	systemdDropins = app.Flag("systemd-dropin", "Systemd drop-in file to install under the service's `.d` directory, can be repeated").
+			ExistingFiles()
+	serviceFile = app.Flag("service-file", "Systemd unit file to package, defaults to the unit name in the current directory").
+			String()
+	printVersionInfo = app.Flag("print-version-info", "Print the versions derived from the release tag and exit").
+				Bool()
+	scriptsDir = app.Flag("scripts-dir", "Directory to pick up package scripts from (preinstall.sh, postinstall.sh, preremove.sh, postremove.sh, preupgrade.sh, postupgrade.sh, pretrans.sh, posttrans.sh)").
+			ExistingDir()
+	debconfTemplates = app.Flag("debconf-templates", "Debconf templates file to include in the deb package").
+				ExistingFile()
+	debconfConfig = app.Flag("debconf-config", "Debconf config script to include in the deb package").
+			ExistingFile()
+	metaFile = app.Flag("meta", "YAML file overriding the package metadata (description, license, maintainer, vendor, homepage, section, summary, file_modes)").
+			ExistingFile()
+	combinedJSON = app.Flag("combined", "Generate a single downloads JSON with both the community and enterprise sections").
+			Bool()
+	osName = app.Flag("os", "Operating system to package binaries for").
+		Default("linux").
+		String()
+	postHook = app.Flag("post-hook", "Command to run for each produced package, `{{.Path}}` is replaced with the package path").
+			String()
+	postHookChecksums = app.Flag("post-hook-checksums", "Also run --post-hook for each checksum file").
+				Bool()
+	dedupeJSON = app.Flag("dedupe-json", "Collapse per-arch entries into a single `all` entry when they are identical for every arch").
+			Bool()
+	checksumSuffix = app.Flag("checksum-suffix", "File extension of the checksum files and of the checksum URLs in the downloads JSON").
+			Default(".sha256sum").
+			String()
+	cpuProfile = app.Flag("cpuprofile", "Write a CPU profile of the run to this file").
+			String()
+	memProfile = app.Flag("memprofile", "Write a heap profile at the end of the run to this file").
+			String()
+	nameMap = app.Flag("name-map", "Override the package name for a packager as `packager=name`, can be repeated").
+		StringMap()
+	latestJSON = app.Flag("latest-json", "Also write latest.json pointing at the current release binaries").
+			Bool()
+	openrcFile = app.Flag("openrc-file", "OpenRC init script to install for apk packages").
+			ExistingFile()
+	summary = app.Flag("summary", "Print a summary of the built packages at the end of the run").
+		Bool()
+	includeChecksum = app.Flag("include-checksum-in-json", "Embed the sha256 of the packages built in this run in the downloads JSON").
+			Bool()
+	channels = app.Flag("channels", "Comma separated channels to generate downloads JSON for, e.g. `release,edge`").
+			Default("release").
+			String()
+	verifyBinaryVersion = app.Flag("verify-binary-version", "Check that the binaries report the release tag in their `--version` output").
+				Bool()
+	sourceDateEpoch = app.Flag("source-date-epoch", "Unix time to set as the modification time of the package and every file in it, for reproducible builds").
+			Envar("SOURCE_DATE_EPOCH").
+			Int64()
+	dlPaths = app.Flag("dl-path", "Override the download URL path segment of an app as `app=segment`, e.g. `minio=server/myminio`, can be repeated").
+		StringMap()
+	warnCollisions = app.Flag("warn-collisions", "Warn when a package is rebuilt under an existing name with a different checksum").
+			Bool()
+	buildNumber = app.Flag("build-number", "Use the third field of a `RELEASE.<ts>.<build>` tag as the patch version").
+			Bool()
+	symlinkFlags = app.Flag("symlink", "Symlink to create in the package as `target:linkpath`, e.g. `/usr/local/bin/minio:/usr/bin/minio`, can be repeated").
+			Strings()
+	traceFlag = app.Flag("trace", "Print the time spent rendering, packaging and checksumming per arch and packager").
+			Bool()
+	attachSig = app.Flag("attach-sig", "Upstream minisign signature of the binary to ship in the package").
+			ExistingFile()
+	obsoletes = app.Flag("obsoletes", "Package replaced by this one, sets rpm Obsoletes and deb Replaces/Breaks, can be repeated").
+			Strings()
+	textInstructions = app.Flag("text-instructions", "Also write the install instructions as plain text to install-<app>.txt").
+				Bool()
+	jsonDir = app.Flag("json-dir", "Directory to write the JSON metadata to, defaults to the release directory").
+		String()
+	crlf = app.Flag("crlf", "Use CRLF line endings in the install text of Windows entries").
+		Bool()
+	dlEnv = app.Flag("dl-env", "Download mirror environment the URLs in the JSON point to").
+		Default("prod").
+		Enum("prod", "staging")
+	digestFile = app.Flag("digest-file", "Write a `sha256:<hex>` .digest file next to each package").
+			Bool()
+	cosign = app.Flag("cosign", "Sign each package with `cosign sign-blob`, implies --digest-file").
+		Bool()
+	noDefaultScripts = app.Flag("no-default-scripts", "Do not fall back to the built-in scripts for those missing from --scripts-dir").
+				Bool()
+	reconcile = app.Flag("reconcile", "Check that the packages referenced by the existing downloads JSON are present in the release directory and exit").
+			Bool()
+	archNotes = app.Flag("arch-note", "Caveat to show for an arch as `arch=text`, can be repeated").
+			StringMap()
+	debCompression = app.Flag("deb-compression", "Compression of the deb data member overriding --compression, `none` speeds up debug builds").
+			Enum("gzip", "xz", "zstd", "none")
+	changelogMD = app.Flag("changelog-md", "Markdown changelog to take the package description from, using the section of the release, it takes precedence over the --meta description").
+			ExistingFile()
+	extraFileFlags = app.Flag("extra-file", "Extra file to install as `src:dst`, a glob src installs every match under the dst directory, can be repeated").
+			Strings()
+	vcsRef = app.Flag("vcs-ref", "Commit the binaries were built from, recorded in the package metadata").
+		String()
+	feedPath = app.Flag("feed", "Add an entry for the release to the Atom feed at this path and exit").
+			String()
+	license = app.Flag("license", "License of the package as an SPDX expression, e.g. \"AGPL-3.0 OR Commercial\"").
+		String()
+	noticeFile = app.Flag("notice-file", "Third-party licenses file to install as /usr/share/doc/<pkg>/THIRD-PARTY").
+			ExistingFile()
+	outputFormat = app.Flag("output-format", "Serialization of the downloads metadata").
+			Default("json").
+			Enum("json", "yaml")
+	lint = app.Flag("lint", "Run packaging policy checks on the built debs and print warnings").
+		Bool()
+	checksumsJSON = app.Flag("checksums-json", "Also write checksums.json listing the checksum URL of every artifact").
+			Bool()
+	incremental = app.Flag("incremental", "Skip rebuilding packages that are newer than their input binary").
+			Bool()
+	includeInstalledSize = app.Flag("include-installed-size-in-json", "Embed the installed size of the packages built in this run in the downloads JSON").
+				Bool()
+	jsonOnlyTag = app.Flag("json-only-tag", "Only regenerate the downloads JSON for this release tag, without packaging or reading local files").
+			String()
+	systemdDir = app.Flag("systemd-dir", "Directory the systemd unit is installed to").
+			Default("/lib/systemd/system").
+			String()
+	versionedInstall = app.Flag("versioned-install", "Install the binary as /usr/local/bin/<app>-<release> with /usr/local/bin/<app> symlinked to it").
+				Bool()
+	pkgRetries = app.Flag("pkg-retries", "Number of times to retry building a package after a transient filesystem error").
+			Default("0").
+			Int()
+	perArchManifest = app.Flag("per-arch-manifest", "Write a SHA256SUMS listing the binary and packages in each <os>-<arch> directory").
+			Bool()
+	archMapFile = app.Flag("arch-map", "YAML file extending the arch names per packager, e.g. `rpm: {riscv64: riscv64}`, new arches are packaged and listed in the downloads JSON").
+			ExistingFile()
+	debTriggerFlags = app.Flag("deb-trigger", "Deb trigger as `[kind:]name`, kind is one of interest, interest-await, interest-noawait, activate, activate-await or activate-noawait (default), can be repeated").
+			Strings()
+	externalLint = app.Flag("external-lint", "Run lintian and rpmlint, when installed, on the built packages and fail on errors").
+			Bool()
+	externalLintWarnings = app.Flag("external-lint-fail-on-warnings", "Also fail --external-lint on warnings").
+				Bool()
+	archSuffixSource = app.Flag("arch-suffix-source", "Input binaries carry the arch after the release tag, e.g. `minio.RELEASE...Z-amd64`").
+				Bool()
+	diffAgainst = app.Flag("diff-against", "Print how the generated release downloads JSON differs from the published one at this URL or path, without writing anything").
+			String()
+	subscriptionNames = app.Flag("subscription", "Subscription tier to generate the enterprise downloads for, can be repeated").
+				Default("Enterprise").
+				Strings()
+	relativeURLs = app.Flag("relative-urls", "Emit root-relative download and checksum URLs, without scheme and host").
+			Bool()
+	defaultsDir = app.Flag("defaults-dir", "Directory of default configuration files to install under /etc/minio as config files kept on upgrade, the deb purges /etc/minio and /etc/default/<app> unless the scripts dir has a postremove.sh").
+			ExistingDir()
+	validateURLsOffline = app.Flag("validate-json-urls-offline", "Check the generated URLs for syntax, arch and version mistakes without fetching them").
+				Bool()
+	signKey = app.Flag("sign-key", "Armored PGP private key to sign the rpm and deb packages with").
+		ExistingFile()
+	signKeyPassphrase = app.Flag("sign-key-passphrase", "Passphrase of --sign-key").
+				Envar("PKGER_SIGN_KEY_PASSPHRASE").
+				String()
+	debSignatureType = app.Flag("deb-signature-type", "Signer role of the deb signature").
+				Default("origin").
+				Enum("origin", "maint", "archive")
+	prevVersion = app.Flag("prev-version", "Previously released package version as `[epoch:]version`, the epoch is bumped when the new version sorts lower").
+			String()
+	checksumsIndex = app.Flag("checksums-index", "Write checksums-index.json mapping every artifact in the release directory to its sha256").
+			Bool()
+	apkSignKey = app.Flag("apk-sign-key", "RSA private key (PEM) to sign the apk packages with, the public key is written next to each apk").
+			ExistingFile()
+	compression = app.Flag("compression", "Compression algorithm of the deb and rpm payloads (gzip, xz, zstd, lzma, none), other formats keep their fixed compression").
+			Default("gzip").
+			String()
+	installName = app.Flag("install-name", "Filename the binary is installed as in /usr/local/bin, defaults to the app name").
+			String()
+	casOut = app.Flag("cas-out", "Also store every package in this directory as `<sha256>`, with `<sha256>.json` naming it").
+		String()
+	compressionLevel = app.Flag("compression-level", "Compression level of the rpm payload, 1-9 for gzip and 1-22 for zstd, nfpm builds deb packages at a fixed level").
+				Int()
+	manifestIn = app.Flag("manifest-in", "Release manifest declaring the app, release, packagers, per-arch binaries and outputs to build, overriding the conventional layout").
+			ExistingFile()
+	macosUniversal = app.Flag("macos-universal", "Add a universal macOS entry when both darwin amd64 and arm64 binaries exist, merging them with lipo when available").
+			Bool()
 )
 
-const tmpl = `name: "{{ .App }}"
-arch: "{{ .Arch }}"
-platform: "{{ .OS }}"
-version: "{{ .SemVerRelease }}"
-maintainer: "MinIO Development <dev@minio.io>"
+const tmpl = `name: {{ quote .App }}
+arch: {{ quote .Arch }}
+platform: {{ quote .OS }}
+version: {{ quote .SemVerRelease }}
+{{- if .Epoch }}
+epoch: {{ quote .Epoch }}
+{{- end }}
+maintainer: {{ quote .Maintainer }}
 description: |
-  {{ .Description }}
-vendor: "MinIO, Inc."
-homepage: "https://min.io"
-license: "AGPLv3"
+{{ indent 2 .Description }}
+{{- if .VCSRef }}
+  .
+{{ indent 2 (printf "Built from commit %s." .VCSRef) }}
+{{- end }}
+vendor: {{ quote .Vendor }}
+homepage: {{ quote .Homepage }}
+license: {{ quote .License }}
+{{- if .Section }}
+section: {{ quote .Section }}
+{{- end }}
+{{- with .Obsoletes }}
+replaces:
+{{- range . }}
+- {{ quote . }}
+{{- end }}
+{{- end }}
 rpm:
   group: Applications/File
-contents:
-- src: {{ .ReleaseDir }}/{{ .OS }}-{{ .Arch }}/{{ .Binary }}.{{ .Release }}
-  dst: /usr/local/bin/{{ .App }}
-{{if eq .Binary "minio" }}
-- src: minio.service
-  dst: /lib/systemd/system/minio.service
-{{end}}
-{{if eq .Binary "aistor" }}
-- src: minio.service
-  dst: /lib/systemd/system/minio.service
-{{end}}
+{{- if .Summary }}
+  summary: {{ quote .Summary }}
+{{- end }}
+  scripts:
+{{- if .Scripts.PreTrans }}
+    pretrans: {{ quote .Scripts.PreTrans }}
+{{- end }}
+{{- if .Scripts.PostTrans }}
+    posttrans: {{ quote .Scripts.PostTrans }}
+{{- end }}
+deb:
+{{- if .DebCompression }}
+  compression: {{ quote .DebCompression }}
+{{- end }}
+{{- if .VCSRef }}
+  fields:
+    Vcs-Ref: {{ quote .VCSRef }}
+{{- end }}
+{{- with .Obsoletes }}
+  breaks:
+{{- range . }}
+  - {{ quote . }}
+{{- end }}
+{{- end }}
+{{- with .DebTriggers }}
+  triggers:
+{{- range $kind, $names := . }}
+    {{ $kind }}:
+{{- range $names }}
+    - {{ quote . }}
+{{- end }}
+{{- end }}
+{{- end }}
+  scripts:
+{{- if .DebconfTemplates }}
+    templates: {{ quote .DebconfTemplates }}
+{{- end }}
+{{- if .DebconfConfig }}
+    config: {{ quote .DebconfConfig }}
+{{- end }}
+scripts:
+{{- if .Scripts.PreInstall }}
+  preinstall: {{ quote .Scripts.PreInstall }}
+{{- end }}
+{{- if .Scripts.PostInstall }}
+  postinstall: {{ quote .Scripts.PostInstall }}
+{{- end }}
+{{- if .Scripts.PreRemove }}
+  preremove: {{ quote .Scripts.PreRemove }}
+{{- end }}
+{{- if .Scripts.PostRemove }}
+  postremove: {{ quote .Scripts.PostRemove }}
+{{- end }}
+apk:
+  scripts:
+{{- if .Scripts.PreUpgrade }}
+    preupgrade: {{ quote .Scripts.PreUpgrade }}
+{{- end }}
+{{- if .Scripts.PostUpgrade }}
+    postupgrade: {{ quote .Scripts.PostUpgrade }}
+{{- end }}
+overrides:
+{{- range $p := .Packagers }}
+  {{ $p }}:
+    contents:
+    - src: {{ quote $.BinarySrc }}
+{{- if $.VersionedInstall }}
+      dst: {{ quote (printf "/usr/local/bin/%s-%s" $.InstallName $.Release) }}
+      file_info:
+        mode: 0755
+    - src: {{ quote (printf "%s-%s" $.InstallName $.Release) }}
+      dst: {{ quote (printf "/usr/local/bin/%s" $.InstallName) }}
+      type: symlink
+{{- else }}
+      dst: {{ quote (printf "/usr/local/bin/%s" $.InstallName) }}
+      file_info:
+        mode: 0755
+{{- end }}
+{{- if and $.Service (systemd $p) }}
+    - src: {{ quote $.ServiceFile }}
+      dst: {{ quote (printf "%s/%s" (unitDir $p) $.Service) }}
+{{- range $.SystemdDropins }}
+    - src: {{ quote . }}
+      dst: {{ quote (printf "/etc/systemd/system/%s.d/%s" $.Service (base .)) }}
+      type: config|noreplace
+{{- end }}
+{{- end }}
+{{- if $.Signature }}
+    - src: {{ quote $.Signature }}
+      dst: {{ quote (printf "/usr/share/%s/%s.minisig" $.App $.App) }}
+{{- end }}
+{{- if $.NoticeFile }}
+    - src: {{ quote $.NoticeFile }}
+      dst: {{ quote (printf "/usr/share/doc/%s/THIRD-PARTY" $.App) }}
+      file_info:
+        mode: 0644
+{{- end }}
+{{- range $.ExtraFiles }}
+    - src: {{ quote .Src }}
+      dst: {{ quote .Dst }}
+{{- end }}
+{{- range $.ConfigFiles }}
+    - src: {{ quote .Src }}
+      dst: {{ quote .Dst }}
+      type: config|noreplace
+{{- end }}
+{{- range $.Symlinks }}
+    - src: {{ quote .Target }}
+      dst: {{ quote .Link }}
+      type: symlink
+{{- end }}
+{{- if and $.OpenRCFile (eq $p "apk") }}
+    - src: {{ quote $.OpenRCFile }}
+      dst: {{ quote (printf "/etc/init.d/%s" $.App) }}
+      file_info:
+        mode: 0755
+{{- end }}
+{{- $unit := and $.Service (systemd $p) }}
+{{- $dflt := index $.DefaultScripts $p }}
+{{- if or (and $unit $dflt.PostInstall) (and $unit $dflt.PreRemove) (and $.DebPostRemove (eq $p "deb")) }}
+    scripts:
+{{- if and $unit $dflt.PostInstall }}
+      postinstall: {{ quote $dflt.PostInstall }}
+{{- end }}
+{{- if and $unit $dflt.PreRemove }}
+      preremove: {{ quote $dflt.PreRemove }}
+{{- end }}
+{{- if and $.DebPostRemove (eq $p "deb") }}
+      postremove: {{ quote $.DebPostRemove }}
+{{- end }}
+{{- end }}
+{{- end }}
 `
 
 type dlInfo struct {
 	Text     string `json:"text"`
 	Checksum string `json:"cksum"`
 	Download string `json:"download"`
+	SHA256   string `json:"sha256,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+
+	InstalledSize int64 `json:"installedSize,omitempty"`
 }
 
 type downloadJSON struct {
 	Text     string  `json:"text,omitempty"`
+	Note     string  `json:"note,omitempty"`
 	Bin      *dlInfo `json:"Binary,omitempty"`
 	RPM      *dlInfo `json:"RPM,omitempty"`
 	Deb      *dlInfo `json:"DEB,omitempty"`
+	APK      *dlInfo `json:"APK,omitempty"`
 	Homebrew *dlInfo `json:"Homebrew,omitempty"`
 }
 
+// downloadsSchemaVersion is the version of the shape of the generated
+// downloads documents, bump it whenever the shape changes.
+const downloadsSchemaVersion = 1
+
 type enterpriseDownloadsJSON struct {
+	SchemaVersion int
 	Subscriptions map[string]downloadsJSON
 }
 
 type downloadsJSON struct {
+	SchemaVersion int `json:"SchemaVersion"`
+
 	Kubernetes map[string]map[string]downloadJSON `json:"Kubernetes"`
 	Docker     map[string]map[string]downloadJSON `json:"Docker,omitempty"`
 	Linux      map[string]map[string]downloadJSON `json:"Linux"`
@@ -116,23 +466,403 @@ type downloadsJSON struct {
 	Windows    map[string]map[string]downloadJSON `json:"Windows"`
 }
 
+// combinedDownloadsJSON holds the community downloads alongside the
+// enterprise subscriptions for the unified downloads page.
+type combinedDownloadsJSON struct {
+	downloadsJSON
+	SchemaVersion int
+	Subscriptions map[string]downloadsJSON
+}
+
+// linuxOnlyPackagers refuse any platform other than linux.
+var linuxOnlyPackagers = map[string]bool{
+	"apk":       true,
+	"archlinux": true,
+	"ipk":       true,
+}
+
+// compressionAlgos lists the --compression values each packager accepts.
+var compressionAlgos = map[string]map[string]bool{
+	"deb": {"gzip": true, "xz": true, "zstd": true, "none": true},
+	"rpm": {"gzip": true, "xz": true, "zstd": true, "lzma": true},
+}
+
+// compressionAlgo returns the payload compression of pkger,
+// --deb-compression overrides --compression for deb.
+func compressionAlgo(pkger string) string {
+	if pkger == "deb" && *debCompression != "" {
+		return *debCompression
+	}
+	return *compression
+}
+
+// compressionLevels are the --compression-level ranges of the
+// algorithms taking a level.
+var compressionLevels = map[string][2]int{
+	"gzip": {1, 9},
+	"zstd": {1, 22},
+}
+
+// platforms returns the platform sections of d keyed by their JSON name.
+func (d downloadsJSON) platforms() map[string]map[string]map[string]downloadJSON {
+	return map[string]map[string]map[string]downloadJSON{
+		"Kubernetes": d.Kubernetes,
+		"Docker":     d.Docker,
+		"Linux":      d.Linux,
+		"macOS":      d.MacOS,
+		"Windows":    d.Windows,
+	}
+}
+
+// allDownloads returns every downloadsJSON section of a generated
+// downloads document.
+func allDownloads(d any) []downloadsJSON {
+	var ds []downloadsJSON
+	switch dj := d.(type) {
+	case downloadsJSON:
+		ds = append(ds, dj)
+	case enterpriseDownloadsJSON:
+		for _, sd := range dj.Subscriptions {
+			ds = append(ds, sd)
+		}
+	case combinedDownloadsJSON:
+		ds = append(ds, dj.downloadsJSON)
+		for _, sd := range dj.Subscriptions {
+			ds = append(ds, sd)
+		}
+	}
+	return ds
+}
+
+// latestDownloadsJSON is a compact pointer to the current release, listing the
+// binary download URL per product and os-arch.
+type latestDownloadsJSON struct {
+	SchemaVersion int `json:"SchemaVersion"`
+
+	Release   string                       `json:"release"`
+	Downloads map[string]map[string]string `json:"downloads"`
+}
+
+var platformOS = map[string]string{
+	"Linux":   "linux",
+	"macOS":   "darwin",
+	"Windows": "windows",
+}
+
+// writeLatestJSON writes latest.json for the generated downloads d
+// into the JSON directory.
+func writeLatestJSON(release string, d any) error {
+	l := latestDownloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
+
+		Release:   release,
+		Downloads: make(map[string]map[string]string),
+	}
+	for _, dj := range allDownloads(d) {
+		for platform, products := range dj.platforms() {
+			goos, ok := platformOS[platform]
+			if !ok {
+				continue
+			}
+			for product, arches := range products {
+				for arch, dl := range arches {
+					if dl.Bin == nil {
+						continue
+					}
+					if _, ok := l.Downloads[product]; !ok {
+						l.Downloads[product] = make(map[string]string)
+					}
+					l.Downloads[product][goos+"-"+arch] = dl.Bin.Download
+				}
+			}
+		}
+	}
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(&l)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(jsonDirName(), "latest.json"), buf)
+}
+
+// writeChecksumsJSON writes checksums.json for the generated downloads
+// d into the JSON directory, it maps every product and os-arch to
+// the checksum URLs of its binary and packages.
+func writeChecksumsJSON(d any) error {
+	sums := make(map[string]map[string]map[string]string)
+	for _, dj := range allDownloads(d) {
+		for platform, products := range dj.platforms() {
+			goos, ok := platformOS[platform]
+			if !ok {
+				continue
+			}
+			for product, arches := range products {
+				for arch, dl := range arches {
+					artifacts := make(map[string]string)
+					for kind, info := range map[string]*dlInfo{"binary": dl.Bin, "rpm": dl.RPM, "deb": dl.Deb, "apk": dl.APK} {
+						if info != nil && info.Checksum != "" {
+							artifacts[kind] = info.Checksum
+						}
+					}
+					if len(artifacts) == 0 {
+						continue
+					}
+					if _, ok := sums[product]; !ok {
+						sums[product] = make(map[string]map[string]string)
+					}
+					sums[product][goos+"-"+arch] = artifacts
+				}
+			}
+		}
+	}
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(sums)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(jsonDirName(), "checksums.json"), buf)
+}
+
+// systemdPackagers are the packagers targeting systemd distros, only
+// their packages ship the systemd unit and drop-ins.
+var systemdPackagers = map[string]bool{
+	"deb":       true,
+	"rpm":       true,
+	"archlinux": true,
+}
+
+// defaultPlatformArches are the arches released per os for apps not
+// listed in appPlatformArches.
+var defaultPlatformArches = map[string][]string{
+	"linux":   {"amd64", "arm64", "ppc64le"},
+	"darwin":  {"amd64", "arm64"},
+	"windows": {"amd64"},
+}
+
+// appPlatformArches lists the arches released per app and os, apps
+// not listed here use defaultPlatformArches.
+var appPlatformArches = map[string]map[string][]string{
+	"minio": {
+		"linux":   {"amd64", "arm64", "ppc64le", "s390x"},
+		"darwin":  {"amd64", "arm64"},
+		"windows": {"amd64"},
+	},
+	"mc": {
+		"linux":   {"amd64", "arm64", "ppc64le"},
+		"darwin":  {"amd64", "arm64"},
+		"windows": {"amd64"},
+	},
+	"minio-enterprise": {
+		"linux": {"amd64", "arm64"},
+	},
+	"mc-enterprise": {
+		"linux": {"amd64", "arm64"},
+	},
+	"kubectl-minio": {
+		"linux":   {"amd64", "arm64", "ppc64le"},
+		"darwin":  {"amd64", "arm64"},
+		"windows": {"amd64"},
+	},
+}
+
+// optionalArches are packaged only when their release binary exists,
+// a missing one is skipped with a warning instead of failing the build.
+var optionalArches = map[string]bool{
+	"s390x": true,
+}
+
+// skippedArches are the optionalArches doPackage skipped, they are
+// left out of the downloads JSON too.
+var skippedArches = map[string]bool{}
+
+// appCapabilities tells which outputs pkger produces for an app.
+type appCapabilities struct {
+	Packages bool
+	JSON     bool
+	// Packagers are the package formats built for the app, every
+	// packager when empty.
+	Packagers []string
+}
+
+// appCapsTable lists the apps that do not get both packages and
+// downloads metadata.
+var appCapsTable = map[string]appCapabilities{
+	"sidekick": {Packages: true},
+	"warp":     {Packages: true},
+}
+
+// appCaps returns the outputs produced for appName, apps not listed in
+// appCapsTable get both packages and downloads metadata.
+func appCaps(appName string) appCapabilities {
+	if caps, ok := appCapsTable[appName]; ok {
+		return caps
+	}
+	return appCapabilities{Packages: true, JSON: true}
+}
+
+// selectPackagers splits the comma separated packager and verifies
+// appName is packaged in each of the formats.
+func selectPackagers(appName, packager string) ([]string, error) {
+	caps := appCaps(appName)
+	var selected []string
+	seen := make(map[string]bool)
+	for _, pkger := range strings.Split(packager, ",") {
+		pkger = strings.TrimSpace(pkger)
+		if pkger == "" || seen[pkger] {
+			continue
+		}
+		if _, ok := pkgArchMaps[pkger]; !ok {
+			return nil, fmt.Errorf("unknown packager %s, expected one of %s", pkger, strings.Join(sortedKeys(pkgArchMaps), ","))
+		}
+		if !packagedAs(appName, pkger) {
+			return nil, fmt.Errorf("%s is not packaged as %s, only as %s", appName, pkger, strings.Join(caps.Packagers, ","))
+		}
+		seen[pkger] = true
+		selected = append(selected, pkger)
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no packager selected")
+	}
+	return selected, nil
+}
+
+// packagedAs tells whether appName is packaged as pkger.
+func packagedAs(appName, pkger string) bool {
+	caps := appCaps(appName)
+	if len(caps.Packagers) == 0 {
+		return true
+	}
+	for _, p := range caps.Packagers {
+		if p == pkger {
+			return true
+		}
+	}
+	return false
+}
+
+// platformArches returns the arches appName is released for on goos.
+func platformArches(appName, goos string) []string {
+	// A manifest lists exactly the arches built, for --os only.
+	if manifest != nil {
+		if goos != *osName {
+			return nil
+		}
+		return sortedKeys(manifest.Binaries)
+	}
+	platforms, ok := appPlatformArches[appName]
+	if !ok {
+		platforms = defaultPlatformArches
+	}
+	return platforms[goos]
+}
+
 var rpmArchMap = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+var debArchMap = map[string]string{
+	"amd64":   "amd64",
+	"arm64":   "arm64",
+	"ppc64le": "ppc64el",
+	"s390x":   "s390x",
+}
+
+var apkArchMap = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+var archLinuxArchMap = map[string]string{
 	"amd64": "x86_64",
 	"arm64": "aarch64",
 }
 
-var debArchMap = map[string]string{
-	"amd64": "amd64",
-	"arm64": "arm64",
+// ipkArchMap maps to the OpenWRT package arches, targets using another
+// arch name (e.g. `aarch64_cortex-a53`) override it with --arch-map.
+var ipkArchMap = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64_generic",
+}
+
+// pkgArchMaps are the arch name maps per packager, extensible with
+// --arch-map.
+var pkgArchMaps = map[string]map[string]string{
+	"rpm":       rpmArchMap,
+	"deb":       debArchMap,
+	"apk":       apkArchMap,
+	"archlinux": archLinuxArchMap,
+	"ipk":       ipkArchMap,
+}
+
+// loadArchMaps merges the per packager arch maps in the YAML file at
+// path into pkgArchMaps. Arches new to appName are added to its linux
+// arches so they are packaged and listed in the downloads JSON, maps
+// of packagers not in packagers are refused as they would go unused.
+func loadArchMaps(path, appName string, packagers []string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var maps map[string]map[string]string
+	if err = yaml.Unmarshal(buf, &maps); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	selected := make(map[string]bool, len(packagers))
+	for _, p := range packagers {
+		selected[p] = true
+	}
+	for _, pkger := range sortedKeys(maps) {
+		archMap, ok := pkgArchMaps[pkger]
+		if !ok {
+			return fmt.Errorf("unknown packager %s in %s", pkger, path)
+		}
+		if !selected[pkger] {
+			return fmt.Errorf("arch map for %s in %s is unused, only %s packages are built", pkger, path, strings.Join(packagers, ","))
+		}
+		for _, arch := range sortedKeys(maps[pkger]) {
+			archMap[arch] = maps[pkger][arch]
+			addPlatformArch(appName, "linux", arch)
+		}
+	}
+	return nil
+}
+
+// addPlatformArch adds arch to the goos arches of appName, unless it
+// is already released.
+func addPlatformArch(appName, goos, arch string) {
+	arches := platformArches(appName, goos)
+	for _, a := range arches {
+		if a == arch {
+			return
+		}
+	}
+	platforms, ok := appPlatformArches[appName]
+	if !ok {
+		platforms = defaultPlatformArches
+	}
+	extended := make(map[string][]string, len(platforms))
+	for g, as := range platforms {
+		extended[g] = as
+	}
+	extended[goos] = append(append([]string{}, arches...), arch)
+	appPlatformArches[appName] = extended
 }
 
 func generateEnterpriseDownloadsJSON(semVerTag, appName string) enterpriseDownloadsJSON {
 	d := enterpriseDownloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
 		Subscriptions: map[string]downloadsJSON{},
 	}
-	d.Subscriptions["Enterprise"] = downloadsJSON{
-		Kubernetes: make(map[string]map[string]downloadJSON),
-		Linux:      make(map[string]map[string]downloadJSON),
+	for _, subscription := range *subscriptionNames {
+		d.Subscriptions[subscription] = downloadsJSON{
+			SchemaVersion: downloadsSchemaVersion,
+
+			Kubernetes: make(map[string]map[string]downloadJSON),
+			Linux:      make(map[string]map[string]downloadJSON),
+		}
 	}
 	for subscription := range d.Subscriptions {
 		d.Subscriptions[subscription].Linux["AIStor Object Store"] = map[string]downloadJSON{}
@@ -144,10 +874,7 @@ func generateEnterpriseDownloadsJSON(semVerTag, appName string) enterpriseDownlo
 	}
 
 	for subscription := range d.Subscriptions {
-		for _, arch := range []string{
-			"amd64",
-			"arm64",
-		} {
+		for _, arch := range platformArches(appName, "linux") {
 			if appName == "mc-enterprise" {
 				d.Subscriptions[subscription].Linux["AIStor MinIO Client"][arch] = downloadJSON{
 					Bin: &dlInfo{
@@ -156,20 +883,20 @@ func generateEnterpriseDownloadsJSON(semVerTag, appName string) enterpriseDownlo
 chmod +x mc
 mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, arch),
 
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc.sha256sum", arch),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc", arch) + *checksumSuffix,
 					},
 					RPM: &dlInfo{
-						Download: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc-%s-1.%s.rpm", arch, semVerTag, rpmArchMap[arch]),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc-%s-1.%s.rpm.sha256sum", arch, semVerTag, rpmArchMap[arch]),
-						Text: fmt.Sprintf(`dnf install https://dl.min.io/aistor/mc/release/linux-%s/mc-%s-1.%s.rpm
-mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, arch, semVerTag, rpmArchMap[arch]),
+						Download: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc-%s.%s.rpm", arch, rpmVersion(semVerTag), rpmArchMap[arch]),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc-%s.%s.rpm", arch, rpmVersion(semVerTag), rpmArchMap[arch]) + *checksumSuffix,
+						Text: fmt.Sprintf(`dnf install https://dl.min.io/aistor/mc/release/linux-%s/mc-%s.%s.rpm
+mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, arch, rpmVersion(semVerTag), rpmArchMap[arch]),
 					},
 					Deb: &dlInfo{
-						Download: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc_%s_%s.deb", arch, semVerTag, debArchMap[arch]),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc_%s_%s.deb.sha256sum", arch, semVerTag, debArchMap[arch]),
+						Download: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc_%s_%s.deb", arch, debVersion(semVerTag), debArchMap[arch]),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mc/release/linux-%s/mc_%s_%s.deb", arch, debVersion(semVerTag), debArchMap[arch]) + *checksumSuffix,
 						Text: fmt.Sprintf(`wget https://dl.min.io/aistor/mc/release/linux-%s/mc_%s_%s.deb
 dpkg -i mc_%s_%s.deb
-mcli alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, arch, semVerTag, debArchMap[arch], semVerTag, debArchMap[arch]),
+mcli alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, arch, debVersion(semVerTag), debArchMap[arch], debVersion(semVerTag), debArchMap[arch]),
 					},
 				}
 			}
@@ -184,7 +911,7 @@ kubectl port-forward svc/aistor -n aistor`,
 						Text: fmt.Sprintf(`wget https://dl.min.io/aistor/minwall/release/linux-%s/minwall
 chmod +x minwall
 ./minwall -c config.yaml`, arch),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minwall/release/linux-%s/minwall.sha256sum", arch),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minwall/release/linux-%s/minwall", arch) + *checksumSuffix,
 					},
 				}
 				d.Subscriptions[subscription].Linux["AIStor Key Manager"][arch] = downloadJSON{
@@ -193,7 +920,7 @@ chmod +x minwall
 						Text: fmt.Sprintf(`wget https://dl.min.io/aistor/minkms/release/linux-%s/minkms
 chmod +x minkms
 ./minkms --help`, arch),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minkms/release/linux-%s/minkms.sha256sum", arch),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minkms/release/linux-%s/minkms", arch) + *checksumSuffix,
 					},
 				}
 				d.Subscriptions[subscription].Linux["AIStor Catalog"][arch] = downloadJSON{
@@ -202,7 +929,7 @@ chmod +x minkms
 						Text: fmt.Sprintf(`wget https://dl.min.io/aistor/mincat/release/linux-%s/mincat
 chmod +x mincat
 ./mincat --help`, arch),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mincat/release/linux-%s/mincat.sha256sum", arch),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/mincat/release/linux-%s/mincat", arch) + *checksumSuffix,
 					},
 				}
 
@@ -212,30 +939,55 @@ chmod +x mincat
 						Text: fmt.Sprintf(`wget https://dl.min.io/aistor/minio/release/linux-%s/minio
 chmod +x minio
 MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password ./minio server /mnt/data --console-address ":9001"`, arch),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio.sha256sum", arch),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio", arch) + *checksumSuffix,
 					},
 					RPM: &dlInfo{
-						Download: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio-%s-1.%s.rpm", arch, semVerTag, rpmArchMap[arch]),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio-%s-1.%s.rpm.sha256sum", arch, semVerTag, rpmArchMap[arch]),
-						Text: fmt.Sprintf(`dnf install https://dl.min.io/aistor/minio/release/linux-%s/minio-%s-1.%s.rpm
-MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, arch, semVerTag, rpmArchMap[arch]),
+						Download: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio-%s.%s.rpm", arch, rpmVersion(semVerTag), rpmArchMap[arch]),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio-%s.%s.rpm", arch, rpmVersion(semVerTag), rpmArchMap[arch]) + *checksumSuffix,
+						Text: fmt.Sprintf(`dnf install https://dl.min.io/aistor/minio/release/linux-%s/minio-%s.%s.rpm
+MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, arch, rpmVersion(semVerTag), rpmArchMap[arch]),
 					},
 					Deb: &dlInfo{
-						Download: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio_%s_%s.deb", arch, semVerTag, debArchMap[arch]),
-						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio_%s_%s.deb.sha256sum", arch, semVerTag, debArchMap[arch]),
+						Download: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio_%s_%s.deb", arch, debVersion(semVerTag), debArchMap[arch]),
+						Checksum: fmt.Sprintf("https://dl.min.io/aistor/minio/release/linux-%s/minio_%s_%s.deb", arch, debVersion(semVerTag), debArchMap[arch]) + *checksumSuffix,
 						Text: fmt.Sprintf(`wget https://dl.min.io/aistor/minio/release/linux-%s/minio_%s_%s.deb
 dpkg -i minio_%s_%s.deb
-MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, arch, semVerTag, debArchMap[arch], semVerTag, debArchMap[arch]),
+MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, arch, debVersion(semVerTag), debArchMap[arch], debVersion(semVerTag), debArchMap[arch]),
 					},
 				}
 			}
+
+			if packagedAs(appName, "apk") {
+				product, segment := "AIStor Object Store", "aistor/minio"
+				if appName == "mc-enterprise" {
+					product, segment = "AIStor MinIO Client", "aistor/mc"
+				}
+				dl := d.Subscriptions[subscription].Linux[product][arch]
+				dl.APK = apkDownload(segment, packageName(appName), arch, semVerTag)
+				d.Subscriptions[subscription].Linux[product][arch] = dl
+			}
 		}
 	}
+	for _, sd := range d.Subscriptions {
+		dropUnpackagedArches(sd)
+		applyNameMap(sd, semVerTag)
+	}
 	return d
 }
 
+func generateCombinedDownloadsJSON(semVerTag, appName string) combinedDownloadsJSON {
+	community := strings.TrimSuffix(appName, "-enterprise")
+	return combinedDownloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
+		downloadsJSON: generateDownloadsJSON(semVerTag, community),
+		Subscriptions: generateEnterpriseDownloadsJSON(semVerTag, community+"-enterprise").Subscriptions,
+	}
+}
+
 func generateDownloadsJSON(semVerTag string, appName string) downloadsJSON {
 	d := downloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
+
 		Linux:      make(map[string]map[string]downloadJSON),
 		MacOS:      make(map[string]map[string]downloadJSON),
 		Windows:    make(map[string]map[string]downloadJSON),
@@ -259,11 +1011,7 @@ func generateDownloadsJSON(semVerTag string, appName string) downloadsJSON {
 		d.Kubernetes["MinIO Client"] = map[string]downloadJSON{}
 	}
 
-	for _, linuxArch := range []string{
-		"amd64",
-		"arm64",
-		"ppc64le",
-	} {
+	for _, linuxArch := range platformArches(appName, "linux") {
 		if appName == "minio" {
 			d.Kubernetes["MinIO Server"][linuxArch] = downloadJSON{
 				Text: `kubectl apply -k github.com/minio/operator`,
@@ -277,20 +1025,20 @@ func generateDownloadsJSON(semVerTag string, appName string) downloadsJSON {
 					Text: fmt.Sprintf(`wget https://dl.min.io/server/minio/release/linux-%s/minio
 chmod +x minio
 MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password ./minio server /mnt/data --console-address ":9001"`, linuxArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio.sha256sum", linuxArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio", linuxArch) + *checksumSuffix,
 				},
 				RPM: &dlInfo{
-					Download: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio-%s-1.%s.rpm", linuxArch, semVerTag, rpmArchMap[linuxArch]),
-					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio-%s-1.%s.rpm.sha256sum", linuxArch, semVerTag, rpmArchMap[linuxArch]),
-					Text: fmt.Sprintf(`dnf install https://dl.min.io/server/minio/release/linux-%s/minio-%s-1.%s.rpm
-MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, linuxArch, semVerTag, rpmArchMap[linuxArch]),
+					Download: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio-%s.%s.rpm", linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]),
+					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio-%s.%s.rpm", linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]) + *checksumSuffix,
+					Text: fmt.Sprintf(`dnf install https://dl.min.io/server/minio/release/linux-%s/minio-%s.%s.rpm
+MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]),
 				},
 				Deb: &dlInfo{
-					Download: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio_%s_%s.deb", linuxArch, semVerTag, debArchMap[linuxArch]),
-					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio_%s_%s.deb.sha256sum", linuxArch, semVerTag, debArchMap[linuxArch]),
+					Download: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio_%s_%s.deb", linuxArch, debVersion(semVerTag), debArchMap[linuxArch]),
+					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/linux-%s/minio_%s_%s.deb", linuxArch, debVersion(semVerTag), debArchMap[linuxArch]) + *checksumSuffix,
 					Text: fmt.Sprintf(`wget https://dl.min.io/server/minio/release/linux-%s/minio_%s_%s.deb
 dpkg -i minio_%s_%s.deb
-MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, linuxArch, semVerTag, debArchMap[linuxArch], semVerTag, debArchMap[linuxArch]),
+MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`, linuxArch, debVersion(semVerTag), debArchMap[linuxArch], debVersion(semVerTag), debArchMap[linuxArch]),
 				},
 			}
 		}
@@ -311,40 +1059,47 @@ MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --cons
 					Text: fmt.Sprintf(`wget https://dl.min.io/client/mc/release/linux-%s/mc
 chmod +x mc
 mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, linuxArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mc.sha256sum", linuxArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mc", linuxArch) + *checksumSuffix,
 				},
 				RPM: &dlInfo{
-					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli-%s-1.%s.rpm", linuxArch, semVerTag, rpmArchMap[linuxArch]),
-					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli-%s-1.%s.rpm.sha256sum", linuxArch, semVerTag, rpmArchMap[linuxArch]),
-					Text: fmt.Sprintf(`dnf install https://dl.min.io/client/mc/release/linux-%s/mcli-%s-1.%s.rpm
-mcli alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, linuxArch, semVerTag, rpmArchMap[linuxArch]),
+					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli-%s.%s.rpm", linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]),
+					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli-%s.%s.rpm", linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]) + *checksumSuffix,
+					Text: fmt.Sprintf(`dnf install https://dl.min.io/client/mc/release/linux-%s/mcli-%s.%s.rpm
+mcli alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]),
 				},
 				Deb: &dlInfo{
-					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli_%s_%s.deb", linuxArch, semVerTag, debArchMap[linuxArch]),
-					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli_%s_%s.deb.sha256sum", linuxArch, semVerTag, debArchMap[linuxArch]),
+					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli_%s_%s.deb", linuxArch, debVersion(semVerTag), debArchMap[linuxArch]),
+					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/linux-%s/mcli_%s_%s.deb", linuxArch, debVersion(semVerTag), debArchMap[linuxArch]) + *checksumSuffix,
 					Text: fmt.Sprintf(`wget https://dl.min.io/client/mc/release/linux-%s/mcli_%s_%s.deb
 dpkg -i mcli_%s_%s.deb
-mcli alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, linuxArch, semVerTag, debArchMap[linuxArch], semVerTag, debArchMap[linuxArch]),
+mcli alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, linuxArch, debVersion(semVerTag), debArchMap[linuxArch], debVersion(semVerTag), debArchMap[linuxArch]),
 				},
 			}
 		}
+
+		if packagedAs(appName, "apk") && (appName == "minio" || appName == "mc") {
+			product, segment := "MinIO Server", "server/minio"
+			if appName == "mc" {
+				product, segment = "MinIO Client", "client/mc"
+			}
+			dl := d.Linux[product][linuxArch]
+			dl.APK = apkDownload(segment, packageName(appName), linuxArch, semVerTag)
+			d.Linux[product][linuxArch] = dl
+		}
 	}
 
-	for _, macArch := range []string{
-		"amd64",
-		"arm64",
-	} {
+	for _, macArch := range platformArches(appName, "darwin") {
 		if appName == "minio" {
 			d.MacOS["MinIO Server"][macArch] = downloadJSON{
 				Homebrew: &dlInfo{
 					Download: fmt.Sprintf("https://dl.min.io/server/minio/release/darwin-%s/minio", macArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/darwin-%s/minio.sha256sum", macArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/darwin-%s/minio", macArch) + *checksumSuffix,
 					Text: `brew install minio/stable/minio
 MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password minio server /mnt/data --console-address ":9001"`,
 				},
 				Bin: &dlInfo{
 					Download: fmt.Sprintf("https://dl.min.io/server/minio/release/darwin-%s/minio", macArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/darwin-%s/minio.sha256sum", macArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/darwin-%s/minio", macArch) + *checksumSuffix,
 					Text: fmt.Sprintf(`curl --progress-bar -O https://dl.min.io/server/minio/release/darwin-%s/minio
 chmod +x minio
 MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password ./minio server /mnt/data --console-address ":9001"`, macArch),
@@ -355,13 +1110,13 @@ MINIO_ROOT_USER=admin MINIO_ROOT_PASSWORD=password ./minio server /mnt/data --co
 			d.MacOS["MinIO Client"][macArch] = downloadJSON{
 				Homebrew: &dlInfo{
 					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/darwin-%s/mc", macArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/darwin-%s/mc.sha256sum", macArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/darwin-%s/mc", macArch) + *checksumSuffix,
 					Text: `brew install minio/stable/mc
 mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`,
 				},
 				Bin: &dlInfo{
 					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/darwin-%s/mc", macArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/darwin-%s/mc.sha256sum", macArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/darwin-%s/mc", macArch) + *checksumSuffix,
 					Text: fmt.Sprintf(`curl --progress-bar -O https://dl.min.io/client/mc/release/darwin-%s/mc
 chmod +x mc
 mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, macArch),
@@ -369,9 +1124,7 @@ mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, macArch),
 			}
 		}
 	}
-	for _, winArch := range []string{
-		"amd64",
-	} {
+	for _, winArch := range platformArches(appName, "windows") {
 		if appName == "minio" {
 			d.Windows["MinIO Server"][winArch] = downloadJSON{
 				Bin: &dlInfo{
@@ -380,7 +1133,7 @@ mc alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, macArch),
 PS> setx MINIO_ROOT_USER admin
 PS> setx MINIO_ROOT_PASSWORD password
 PS> C:\minio.exe server F:\Data --console-address ":9001"`, winArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/windows-%s/minio.exe.sha256sum", winArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/server/minio/release/windows-%s/minio.exe", winArch) + *checksumSuffix,
 				},
 			}
 		}
@@ -390,247 +1143,3160 @@ PS> C:\minio.exe server F:\Data --console-address ":9001"`, winArch),
 					Download: fmt.Sprintf("https://dl.min.io/client/mc/release/windows-%s/mc.exe", winArch),
 					Text: fmt.Sprintf(`PS> Invoke-WebRequest -Uri "https://dl.minio.io/client/mc/release/windows-%s/mc.exe" -OutFile "C:\mc.exe"
 C:\mc.exe alias set myminio/ http://MINIO-SERVER MYUSER MYPASSWORD`, winArch),
-					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/windows-%s/mc.exe.sha256sum", winArch),
+					Checksum: fmt.Sprintf("https://dl.min.io/client/mc/release/windows-%s/mc.exe", winArch) + *checksumSuffix,
 				},
 			}
 		}
 	}
+	dropUnpackagedArches(d)
+	applyNameMap(d, semVerTag)
 	return d
 }
 
-func releaseDirName() string {
-	if *releaseDir != "" {
-		return *releaseDir
+func generateKubectlMinioDownloadsJSON(semVerTag string) downloadsJSON {
+	d := downloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
+
+		Linux:      map[string]map[string]downloadJSON{"MinIO Operator": {}},
+		MacOS:      map[string]map[string]downloadJSON{"MinIO Operator": {}},
+		Windows:    map[string]map[string]downloadJSON{"MinIO Operator": {}},
+		Kubernetes: map[string]map[string]downloadJSON{"MinIO Operator": {}},
 	}
-	name := *appName
-	switch name {
-	case "minio-enterprise":
-		name = "minio"
-	case "mc-enterprise":
-		name = "mc"
+	for _, linuxArch := range platformArches("kubectl-minio", "linux") {
+		d.Kubernetes["MinIO Operator"][linuxArch] = downloadJSON{
+			Text: `kubectl krew update
+kubectl krew install minio
+kubectl minio init
+kubectl apply -k github.com/minio/operator`,
+		}
+		d.Linux["MinIO Operator"][linuxArch] = downloadJSON{
+			Bin: &dlInfo{
+				Download: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio", linuxArch),
+				Text: fmt.Sprintf(`wget https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio
+chmod +x kubectl-minio
+mv kubectl-minio /usr/local/bin/
+kubectl minio init`, linuxArch),
+				Checksum: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio", linuxArch) + *checksumSuffix,
+			},
+			RPM: &dlInfo{
+				Download: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio-%s.%s.rpm", linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]),
+				Checksum: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio-%s.%s.rpm", linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]) + *checksumSuffix,
+				Text: fmt.Sprintf(`dnf install https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio-%s.%s.rpm
+kubectl minio init`, linuxArch, rpmVersion(semVerTag), rpmArchMap[linuxArch]),
+			},
+			Deb: &dlInfo{
+				Download: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio_%s_%s.deb", linuxArch, debVersion(semVerTag), debArchMap[linuxArch]),
+				Checksum: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio_%s_%s.deb", linuxArch, debVersion(semVerTag), debArchMap[linuxArch]) + *checksumSuffix,
+				Text: fmt.Sprintf(`wget https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio_%s_%s.deb
+dpkg -i kubectl-minio_%s_%s.deb
+kubectl minio init`, linuxArch, debVersion(semVerTag), debArchMap[linuxArch], debVersion(semVerTag), debArchMap[linuxArch]),
+			},
+		}
+		if packagedAs("kubectl-minio", "apk") {
+			dl := d.Linux["MinIO Operator"][linuxArch]
+			dl.APK = apkDownload("operator/kubectl-minio", packageName("kubectl-minio"), linuxArch, semVerTag)
+			d.Linux["MinIO Operator"][linuxArch] = dl
+		}
 	}
-	return name + "-release"
+	for _, macArch := range platformArches("kubectl-minio", "darwin") {
+		d.MacOS["MinIO Operator"][macArch] = downloadJSON{
+			Bin: &dlInfo{
+				Download: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/darwin-%s/kubectl-minio", macArch),
+				Checksum: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/darwin-%s/kubectl-minio", macArch) + *checksumSuffix,
+				Text: fmt.Sprintf(`curl --progress-bar -O https://dl.min.io/operator/kubectl-minio/release/darwin-%s/kubectl-minio
+chmod +x kubectl-minio
+mv kubectl-minio /usr/local/bin/
+kubectl minio init`, macArch),
+			},
+		}
+	}
+	for _, winArch := range platformArches("kubectl-minio", "windows") {
+		d.Windows["MinIO Operator"][winArch] = downloadJSON{
+			Bin: &dlInfo{
+				Download: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/windows-%s/kubectl-minio.exe", winArch),
+				Text: fmt.Sprintf(`PS> Invoke-WebRequest -Uri "https://dl.min.io/operator/kubectl-minio/release/windows-%s/kubectl-minio.exe" -OutFile "C:\kubectl-minio.exe"
+PS> C:\kubectl-minio.exe init`, winArch),
+				Checksum: fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/windows-%s/kubectl-minio.exe", winArch) + *checksumSuffix,
+			},
+		}
+	}
+	dropUnpackagedArches(d)
+	applyNameMap(d, semVerTag)
+	return d
 }
 
-func main() {
-	app.Version(version)
-	app.VersionFlag.Short('v')
-	app.HelpFlag.Short('h')
-	if _, err := app.Parse(os.Args[1:]); err != nil {
-		kingpin.Fatalf(err.Error())
+// apkDownload returns the APK entry of the package name downloaded from
+// the segment path for linuxArch, nil when apk has no name for the arch.
+func apkDownload(segment, name, linuxArch, semVerTag string) *dlInfo {
+	apkArch, ok := apkArchMap[linuxArch]
+	if !ok {
+		return nil
+	}
+	pkg := fmt.Sprintf("%s_%s_%s.apk", name, apkVersion(semVerTag), apkArch)
+	url := fmt.Sprintf("https://dl.min.io/%s/release/linux-%s/%s", segment, linuxArch, pkg)
+	return &dlInfo{
+		Download: url,
+		Checksum: url + *checksumSuffix,
+		Text: fmt.Sprintf(`wget %s
+apk add --allow-untrusted %s`, url, pkg),
 	}
+}
 
-	semVerTag := semVerRelease(*release)
-	if err := doPackage(*appName, *release, *packager); err != nil {
-		if !*ignoreMissingArch {
-			kingpin.Fatalf(err.Error())
-		} else {
-			kingpin.Errorf(err.Error())
+// dropUnpackagedArches removes the RPM and DEB entries of the linux
+// arches without an rpm or deb arch name, e.g. one added by --arch-map
+// for a single packager, instead of linking to packages that do not
+// exist. With a manifest the formats it does not list are removed too.
+func dropUnpackagedArches(d downloadsJSON) {
+	for _, arches := range d.Linux {
+		for arch, dl := range arches {
+			if _, ok := rpmArchMap[arch]; !ok || !manifestPackager("rpm") {
+				dl.RPM = nil
+			}
+			if _, ok := debArchMap[arch]; !ok || !manifestPackager("deb") {
+				dl.Deb = nil
+			}
+			if !manifestPackager("apk") {
+				dl.APK = nil
+			}
+			arches[arch] = dl
 		}
 	}
+}
 
-	var d any
-	json := jsoniter.ConfigCompatibleWithStandardLibrary
-	switch *appName {
-	case "minio-enterprise", "mc-enterprise":
-		d = generateEnterpriseDownloadsJSON(semVerTag, *appName)
-	default:
-		d = generateDownloadsJSON(semVerTag, *appName)
+// dropSkippedArches removes the entries of the skippedArches from
+// every platform of d, there is nothing published to link to.
+func dropSkippedArches(d any) {
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for arch := range skippedArches {
+					delete(arches, arch)
+				}
+			}
+		}
 	}
+}
 
-	buf, err := json.Marshal(&d)
-	if err != nil {
-		kingpin.Fatalf(err.Error())
+// applyNameMap renames the linux packages in d after --name-map, the
+// generators link to the default package names.
+func applyNameMap(d downloadsJSON, semVerTag string) {
+	if len(*nameMap) == 0 {
+		return
+	}
+	for _, arches := range d.Linux {
+		for _, dl := range arches {
+			for _, p := range []struct {
+				pkger string
+				info  *dlInfo
+				// sep precedes the version in the file name.
+				sep string
+			}{
+				{"rpm", dl.RPM, "-" + rpmVersion(semVerTag)},
+				{"deb", dl.Deb, "_" + debVersion(semVerTag)},
+				{"apk", dl.APK, "_" + apkVersion(semVerTag)},
+			} {
+				name, ok := (*nameMap)[p.pkger]
+				if !ok || p.info == nil {
+					continue
+				}
+				base := path.Base(p.info.Download)
+				i := strings.Index(base, p.sep)
+				if i <= 0 {
+					continue
+				}
+				r := strings.NewReplacer(base, name+base[i:])
+				p.info.Download = r.Replace(p.info.Download)
+				p.info.Checksum = r.Replace(p.info.Checksum)
+				p.info.Text = r.Replace(p.info.Text)
+			}
+		}
 	}
-
-	os.WriteFile(filepath.Join(releaseDirName(), "downloads-"+*appName+".json"), buf, 0o644)
-
-	fmt.Println("Generated downloads metadata at", filepath.Join(releaseDirName(), "downloads-"+*appName+".json"))
 }
 
-type releaseTmpl struct {
-	App           string
-	ReleaseDir    string
-	Binary        string
-	Description   string
-	OS            string
-	Arch          string
-	Release       string
-	SemVerRelease string
+// pivotByArch reorganizes d from platform -> product -> arch into
+// arch -> platform, for front-ends that list downloads per arch.
+// Community downloads carry a single product per platform, so the
+// product level is dropped.
+func pivotByArch(d downloadsJSON) archDownloadsJSON {
+	p := archDownloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
+		Arches:        make(map[string]map[string]downloadJSON),
+	}
+	for platform, products := range d.platforms() {
+		for _, arches := range products {
+			for arch, dl := range arches {
+				if _, ok := p.Arches[arch]; !ok {
+					p.Arches[arch] = make(map[string]downloadJSON)
+				}
+				p.Arches[arch][platform] = dl
+			}
+		}
+	}
+	return p
 }
 
-const (
-	minioReleaseTagTimeLayout    = "2006-01-02T15-04-05Z"
-	minioPkgReleaseTagTimeLayout = "20060102150405"
-)
+// archDownloadsJSON is the `--json-layout arch` document, the downloads
+// keyed by arch and then platform.
+type archDownloadsJSON struct {
+	SchemaVersion int
+	Arches        map[string]map[string]downloadJSON
+}
 
-// releaseTagToReleaseTime - reverse of `releaseTimeToReleaseTag()`
-func releaseTagToReleaseTime(releaseTag string) (releaseTime time.Time, fields []string, err error) {
-	fields = strings.Split(releaseTag, ".")
-	if len(fields) < 2 || len(fields) > 4 {
-		return releaseTime, nil, fmt.Errorf("%s is not a valid release tag", releaseTag)
+// packageName returns the package name used for appName.
+func packageName(appName string) string {
+	if appName == "minio-enterprise" {
+		return "minio"
 	}
-	if fields[0] != "RELEASE" {
-		return releaseTime, nil, fmt.Errorf("%s is not a valid release tag", releaseTag)
+	if appName == "mc" || appName == "mc-enterprise" {
+		return "mcli"
 	}
-	releaseTime, err = time.Parse(minioReleaseTagTimeLayout, fields[1])
-	return releaseTime, fields, err
+	return appName
 }
 
-func semVerRelease(release string) string {
-	rtime, fields, err := releaseTagToReleaseTime(release)
-	if err != nil {
-		panic(err)
+// packagerName returns the package name of appName for pkger, the
+// --name-map override or packageName.
+func packagerName(appName, pkger string) string {
+	if name, ok := (*nameMap)[pkger]; ok {
+		return name
 	}
-	var hotfixStr string
-	if len(fields) == 4 {
-		hotfixStr = fields[2] + "." + fields[3]
+	return packageName(appName)
+}
+
+// binaryName returns the name of the released binary for appName.
+func binaryName(appName string) string {
+	if appName == "minio-enterprise" {
+		return "minio"
 	}
-	if hotfixStr != "" {
-		return rtime.Format(minioPkgReleaseTagTimeLayout) + ".0.0." + hotfixStr
+	if appName == "mc-enterprise" {
+		return "mc"
 	}
-	return rtime.Format(minioPkgReleaseTagTimeLayout) + ".0.0"
+	return appName
 }
 
-// nolint:funlen
-func doPackage(appName, release, packager string) error {
-	mtmpl, err := template.New("minio").Parse(tmpl)
-	if err != nil {
-		return err
+// serviceName returns the systemd unit shipped with appName, if any.
+func serviceName(appName string) string {
+	switch appName {
+	case "minio", "minio-enterprise", "aistor":
+		return "minio.service"
 	}
+	return ""
+}
 
-	semVerTag := semVerRelease(release)
-	for _, arch := range []string{
-		"amd64",
-		"arm64",
-		"ppc64le",
-	} {
-		if appName == "minio-enterprise" && arch != "amd64" && arch != "arm64" {
-			continue
-		}
-		if appName == "mc-enterprise" && arch != "amd64" && arch != "arm64" {
-			continue
+// dedupeArches replaces the per-arch entries of every product whose
+// entries are identical across all arches with a single "all" entry.
+func dedupeArches(d downloadsJSON) {
+	for _, products := range d.platforms() {
+		for product, arches := range products {
+			if len(arches) < 2 {
+				continue
+			}
+			var first *downloadJSON
+			same := true
+			for _, dl := range arches {
+				dl := dl
+				if first == nil {
+					first = &dl
+					continue
+				}
+				if !reflect.DeepEqual(*first, dl) {
+					same = false
+					break
+				}
+			}
+			if same {
+				products[product] = map[string]downloadJSON{"all": *first}
+			}
 		}
+	}
+}
 
+// embedChecksums sets the SHA256 of the RPM and DEB entries of d to
+// the digest of the matching package built in this run.
+func embedChecksums(d any, built []builtPackage) {
+	digests := make(map[string]string, len(built))
+	for _, b := range built {
+		digests[filepath.Base(b.Path)] = b.SHA256
+	}
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for _, dl := range arches {
+					for _, info := range []*dlInfo{dl.RPM, dl.Deb, dl.APK} {
+						if info == nil {
+							continue
+						}
+						info.SHA256 = digests[path.Base(info.Download)]
+					}
+				}
+			}
+		}
+	}
+}
+
+// embedInstalledSizes sets the installed size of the rpm and deb
+// entries of d to the one declared by the packages in built.
+func embedInstalledSizes(d any, built []builtPackage) {
+	sizes := make(map[string]int64, len(built))
+	for _, b := range built {
+		sizes[filepath.Base(b.Path)] = b.InstalledSize
+	}
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for _, dl := range arches {
+					for _, info := range []*dlInfo{dl.RPM, dl.Deb, dl.APK} {
+						if info == nil {
+							continue
+						}
+						info.InstalledSize = sizes[path.Base(info.Download)]
+					}
+				}
+			}
+		}
+	}
+}
+
+// embedSizes sets the size in bytes of the binaries and packages built
+// in this run on their entries of d, entries of artifacts built
+// elsewhere are left without a size.
+func embedSizes(d any, appName, release string, built []builtPackage) {
+	// Keyed by `<os>-<arch>/<file>`, the tail of the download URLs.
+	sizes := make(map[string]int64, len(built))
+	for _, b := range built {
+		key := *osName + "-" + b.Arch + "/"
+		sizes[key+filepath.Base(b.Path)] = b.Size
+		if fi, err := os.Stat(sourceBinary(appName, release, b.Arch)); err == nil {
+			sizes[key+binaryName(appName)] = fi.Size()
+		}
+	}
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for _, dl := range arches {
+					for _, info := range []*dlInfo{dl.Bin, dl.RPM, dl.Deb, dl.APK} {
+						if info == nil {
+							continue
+						}
+						key := path.Base(path.Dir(info.Download)) + "/" + path.Base(info.Download)
+						if size, ok := sizes[key]; ok {
+							info.Size = size
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// addArchNotes sets the note of every entry of d for an arch listed
+// in notes.
+func addArchNotes(d any, notes map[string]string) {
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for arch, dl := range arches {
+					if note, ok := notes[arch]; ok {
+						dl.Note = note
+						arches[arch] = dl
+					}
+				}
+			}
+		}
+	}
+}
+
+// windowsCRLF rewrites the install text of the Windows entries of d to
+// use CRLF line endings.
+func windowsCRLF(d downloadsJSON) {
+	toCRLF := func(s string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	}
+	for _, arches := range d.Windows {
+		for arch, dl := range arches {
+			dl.Text = toCRLF(dl.Text)
+			for _, info := range []*dlInfo{dl.Bin, dl.RPM, dl.Deb, dl.APK, dl.Homebrew} {
+				if info != nil {
+					info.Text = toCRLF(info.Text)
+				}
+			}
+			arches[arch] = dl
+		}
+	}
+}
+
+// writeTextInstructions writes the install instructions of the
+// generated downloads d as plain text into the JSON directory, grouped
+// by platform and arch.
+func writeTextInstructions(d any) error {
+	var b strings.Builder
+	for _, dj := range allDownloads(d) {
+		platforms := dj.platforms()
+		for _, platform := range []string{"Linux", "macOS", "Windows", "Docker", "Kubernetes"} {
+			products := platforms[platform]
+			for _, product := range sortedKeys(products) {
+				arches := products[product]
+				for _, arch := range sortedKeys(arches) {
+					dl := arches[arch]
+					fmt.Fprintf(&b, "## %s - %s (%s)\n\n", product, platform, arch)
+					if dl.Text != "" {
+						fmt.Fprintf(&b, "%s\n\n", dl.Text)
+					}
+					for _, info := range []struct {
+						kind string
+						info *dlInfo
+					}{
+						{"Binary", dl.Bin},
+						{"RPM", dl.RPM},
+						{"DEB", dl.Deb},
+						{"APK", dl.APK},
+						{"Homebrew", dl.Homebrew},
+					} {
+						if info.info == nil {
+							continue
+						}
+						fmt.Fprintf(&b, "### %s\n\n%s\n\n", info.kind, info.info.Text)
+					}
+				}
+			}
+		}
+	}
+	return writeFileAtomic(filepath.Join(jsonDirName(), "install-"+*appName+".txt"), []byte(b.String()))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonToYAML converts the JSON document buf to YAML, going through the
+// JSON encoding keeps the JSON field names.
+func jsonToYAML(buf []byte) ([]byte, error) {
+	var v any
+	if err := jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// yamlToJSON converts the YAML document buf written by jsonToYAML back
+// to JSON.
+func yamlToJSON(buf []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v)
+}
+
+// reconcileDownloads checks that every RPM and DEB referenced by the
+// downloads JSON at jsonPath exists in the release directory with a
+// matching checksum, returning a description of each mismatch.
+func reconcileDownloads(jsonPath string) ([]string, error) {
+	buf, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+	if *outputFormat == "yaml" {
+		if buf, err = yamlToJSON(buf); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", jsonPath, err)
+		}
+	}
+	var d combinedDownloadsJSON
+	if err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(buf, &d); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", jsonPath, err)
+	}
+
+	var problems []string
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for _, dl := range arches {
+					for _, info := range []*dlInfo{dl.RPM, dl.Deb, dl.APK} {
+						if info == nil {
+							continue
+						}
+						local := filepath.Join(releaseDirName(), path.Base(path.Dir(info.Download)), path.Base(info.Download))
+						sum, err := sha256File(local)
+						if err != nil {
+							problems = append(problems, fmt.Sprintf("missing: %s (%s)", local, info.Download))
+							continue
+						}
+						want := info.SHA256
+						if want == "" {
+							if buf, err := os.ReadFile(local + *checksumSuffix); err == nil {
+								if fields := strings.Fields(string(buf)); len(fields) > 0 {
+									want = fields[0]
+								}
+							}
+						}
+						if want != "" && want != sum {
+							problems = append(problems, fmt.Sprintf("checksum mismatch: %s is %s, expected %s", local, sum, want))
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// diffDownloads compares the URLs of the downloads JSON buf with the
+// published one at src, a URL or a local path, and returns the added
+// (+), removed (-) and changed (~) ones.
+func diffDownloads(src string, buf []byte) ([]string, error) {
+	var published []byte
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		client := &http.Client{Timeout: time.Minute}
+		resp, err := client.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unable to fetch %s: %s", src, resp.Status)
+		}
+		if published, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if published, err = os.ReadFile(src); err != nil {
+			return nil, err
+		}
+	}
+
+	var oldDoc, newDoc any
+	if err := jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(published, &oldDoc); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", src, err)
+	}
+	if err := jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(buf, &newDoc); err != nil {
+		return nil, err
+	}
+	oldURLs, newURLs := make(map[string]string), make(map[string]string)
+	collectURLs("", oldDoc, oldURLs)
+	collectURLs("", newDoc, newURLs)
+	// Root-relative URLs are compared by path, whichever origin the
+	// published document was generated with.
+	if *relativeURLs {
+		for _, urls := range []map[string]string{oldURLs, newURLs} {
+			for k, u := range urls {
+				urls[k] = urlOriginRegex.ReplaceAllString(u, "")
+			}
+		}
+	}
+
+	var changes []string
+	for _, k := range sortedKeys(newURLs) {
+		old, ok := oldURLs[k]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s: %s", k, newURLs[k]))
+		case old != newURLs[k]:
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", k, old, newURLs[k]))
+		}
+	}
+	for _, k := range sortedKeys(oldURLs) {
+		if _, ok := newURLs[k]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s: %s", k, oldURLs[k]))
+		}
+	}
+	return changes, nil
+}
+
+// collectURLs records every URL string in the decoded JSON v under its
+// slash separated key path, the download and checksum URLs also when
+// they are root-relative.
+func collectURLs(prefix string, v any, urls map[string]string) {
+	switch vv := v.(type) {
+	case map[string]any:
+		for k, e := range vv {
+			collectURLs(prefix+"/"+k, e, urls)
+		}
+	case []any:
+		for i, e := range vv {
+			collectURLs(prefix+"/"+strconv.Itoa(i), e, urls)
+		}
+	case string:
+		key := path.Base(prefix)
+		relative := strings.HasPrefix(vv, "/") && (key == "download" || key == "cksum")
+		if strings.HasPrefix(vv, "http://") || strings.HasPrefix(vv, "https://") || relative {
+			urls[prefix] = vv
+		}
+	}
+}
+
+// sha256File returns the hex sha256 of the file at path.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sh := sha256.New()
+	if _, err = io.Copy(sh, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sh.Sum(nil)), nil
+}
+
+var urlPlatformRegex = regexp.MustCompile(`/(linux|darwin|windows)-([^/]*)/`)
+
+// validateURLs checks the download and checksum URLs of d without
+// fetching them: they must be well formed, name the arch of their
+// entry and, for packages, the version of semVerTag.
+func validateURLs(d any, semVerTag string) []string {
+	var problems []string
+	for _, dj := range allDownloads(d) {
+		for platform, products := range dj.platforms() {
+			for product, arches := range products {
+				for arch, dl := range arches {
+					for _, e := range []struct {
+						kind    string
+						info    *dlInfo
+						version string
+						archs   map[string]string
+					}{
+						{"Binary", dl.Bin, "", nil},
+						{"RPM", dl.RPM, rpmVersion(semVerTag), rpmArchMap},
+						{"DEB", dl.Deb, debVersion(semVerTag), debArchMap},
+						{"APK", dl.APK, apkVersion(semVerTag), apkArchMap},
+					} {
+						if e.info == nil {
+							continue
+						}
+						where := fmt.Sprintf("%s/%s/%s/%s", platform, product, arch, e.kind)
+						for _, p := range checkURL(e.info.Download, arch, e.version, e.archs) {
+							problems = append(problems, fmt.Sprintf("%s: %s: %s", where, e.info.Download, p))
+						}
+						if e.info.Checksum != e.info.Download+*checksumSuffix {
+							problems = append(problems, fmt.Sprintf("%s: checksum URL %s does not match the download", where, e.info.Checksum))
+						}
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// checkURL returns the problems of the download URL u of an arch entry,
+// a package URL must carry version and the packager arch name from
+// archs.
+func checkURL(u, arch, version string, archs map[string]string) []string {
+	var problems []string
+	pu, err := url.Parse(u)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	switch {
+	case *relativeURLs:
+		if pu.Scheme != "" || pu.Host != "" || !strings.HasPrefix(pu.Path, "/") {
+			problems = append(problems, "not a root-relative URL")
+		}
+	case pu.Scheme != "https" && pu.Scheme != "http", pu.Host == "":
+		problems = append(problems, "missing scheme or host")
+	}
+	if strings.Contains(pu.Path, "//") {
+		problems = append(problems, "double slash in path")
+	}
+	if m := urlPlatformRegex.FindStringSubmatch(pu.Path); m == nil {
+		problems = append(problems, "no <os>-<arch> directory")
+	} else if arch != "all" && m[2] != arch {
+		problems = append(problems, fmt.Sprintf("directory is for %s, entry is %s", m[2], arch))
+	}
+	name := path.Base(pu.Path)
+	if version != "" && !strings.Contains(name, version) {
+		problems = append(problems, "version "+version+" missing from the file name")
+	}
+	if archs != nil && arch != "all" {
+		if a := archs[arch]; a == "" || !strings.Contains(name, a) {
+			problems = append(problems, "no package arch name for "+arch+" in the file name")
+		}
+	}
+	return problems
+}
+
+var urlOriginRegex = regexp.MustCompile(`^[a-z]+://[^/]+`)
+
+// relativizeURLs strips the scheme and host from the download and
+// checksum URLs of d, the install text keeps absolute URLs to remain
+// runnable.
+func relativizeURLs(d any) {
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for _, dl := range arches {
+					for _, info := range []*dlInfo{dl.Bin, dl.RPM, dl.Deb, dl.APK, dl.Homebrew} {
+						if info == nil {
+							continue
+						}
+						info.Download = urlOriginRegex.ReplaceAllString(info.Download, "")
+						info.Checksum = urlOriginRegex.ReplaceAllString(info.Checksum, "")
+					}
+				}
+			}
+		}
+	}
+}
+
+// rewriteDownloads replaces every text, download and checksum string
+// of d with fn applied to it.
+func rewriteDownloads(d any, fn func(string) string) {
+	for _, dj := range allDownloads(d) {
+		for _, products := range dj.platforms() {
+			for _, arches := range products {
+				for arch, dl := range arches {
+					dl.Text = fn(dl.Text)
+					for _, info := range []*dlInfo{dl.Bin, dl.RPM, dl.Deb, dl.APK, dl.Homebrew} {
+						if info == nil {
+							continue
+						}
+						info.Text = fn(info.Text)
+						info.Download = fn(info.Download)
+						info.Checksum = fn(info.Checksum)
+					}
+					arches[arch] = dl
+				}
+			}
+		}
+	}
+}
+
+// dlEnvHosts maps the --dl-env environments to their download host.
+var dlEnvHosts = map[string]string{
+	"prod":    "dl.min.io",
+	"staging": "dl-staging.min.io",
+}
+
+// dlPathSegments are the default download URL path segments per app,
+// overridable with --dl-path.
+var dlPathSegments = map[string]string{
+	"minio":            "server/minio",
+	"mc":               "client/mc",
+	"minio-enterprise": "aistor/minio",
+	"mc-enterprise":    "aistor/mc",
+	"minwall":          "aistor/minwall",
+	"minkms":           "aistor/minkms",
+	"mincat":           "aistor/mincat",
+	"kubectl-minio":    "operator/kubectl-minio",
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// updateFeed prepends an entry for release of appName to the Atom feed
+// at path, creating the feed if it does not exist yet. Releases already
+// in the feed are left alone.
+func updateFeed(path, appName, release string) error {
+	rtime, _, err := releaseTagToReleaseTime(release)
+	if err != nil {
+		return err
+	}
+	segment, ok := dlPathSegments[appName]
+	if !ok {
+		return fmt.Errorf("no download path known for %s", appName)
+	}
+	if s, ok := (*dlPaths)[appName]; ok {
+		segment = strings.Trim(s, "/")
+	}
+	releaseURL := fmt.Sprintf("https://%s/%s/release/", dlEnvHosts[*dlEnv], segment)
+
+	feed := atomFeed{
+		Title: appName + " releases",
+		ID:    releaseURL,
+	}
+	buf, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err = xml.Unmarshal(buf, &feed); err != nil {
+			return fmt.Errorf("unable to parse %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	id := releaseURL + release
+	for _, e := range feed.Entries {
+		if e.ID == id {
+			return nil
+		}
+	}
+	updated := rtime.UTC().Format(time.RFC3339)
+	feed.Entries = append([]atomEntry{{
+		Title:   appName + " " + release,
+		ID:      id,
+		Updated: updated,
+		Link:    atomLink{Href: releaseURL},
+	}}, feed.Entries...)
+	if feed.Updated < updated {
+		feed.Updated = updated
+	}
+
+	buf, err = xml.MarshalIndent(&feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append([]byte(xml.Header), buf...))
+}
+
+// writeFileAtomic writes buf to a temporary file next to path and
+// renames it into place, so path is never left partially written.
+func writeFileAtomic(path string, buf []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Chmod(tmp, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// downloadsJSONPath returns where the downloads JSON for channel is
+// written, the release channel keeps the historical name.
+func downloadsJSONPath(channel string) string {
+	name := "downloads-" + *appName
+	if channel != "release" {
+		name += "-" + channel
+	}
+	return filepath.Join(jsonDirName(), name+"."+*outputFormat)
+}
+
+// jsonDirName returns the directory the JSON metadata is written to.
+func jsonDirName() string {
+	if *jsonDir != "" {
+		return *jsonDir
+	}
+	return releaseDirName()
+}
+
+func releaseDirName() string {
+	if *releaseDir != "" {
+		return *releaseDir
+	}
+	name := *appName
+	switch name {
+	case "minio-enterprise":
+		name = "minio"
+	case "mc-enterprise":
+		name = "mc"
+	}
+	return name + "-release"
+}
+
+// profileFlushers stop and write the --cpuprofile and --memprofile
+// profiles.
+var profileFlushers []func()
+
+// flushProfiles writes the profiles, only the first call does.
+func flushProfiles() {
+	for i := len(profileFlushers) - 1; i >= 0; i-- {
+		profileFlushers[i]()
+	}
+	profileFlushers = nil
+}
+
+func main() {
+	app.Version(version)
+	app.VersionFlag.Short('v')
+	app.HelpFlag.Short('h')
+	if _, err := app.Parse(os.Args[1:]); err != nil {
+		kingpin.Fatalf(err.Error())
+	}
+
+	// kingpin.Fatalf exits without running deferred calls, the
+	// profiles are flushed before exiting so failed runs keep them.
+	kingpin.CommandLine.Terminate(func(status int) {
+		flushProfiles()
+		os.Exit(status)
+	})
+	defer flushProfiles()
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+		if err = pprof.StartCPUProfile(f); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+		profileFlushers = append(profileFlushers, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+	if *memProfile != "" {
+		profileFlushers = append(profileFlushers, func() {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "unable to write heap profile:", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err = pprof.WriteHeapProfile(f); err != nil {
+				fmt.Fprintln(os.Stderr, "unable to write heap profile:", err)
+			}
+		})
+	}
+
+	if *manifestIn != "" {
+		m, err := loadManifest(*manifestIn)
+		if err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+		manifest = &m
+		*appName = m.App
+		*release = m.Release
+		*packager = strings.Join(m.Packagers, ",")
+	}
+
+	// The "all" entries of --dedupe-json would show up as an arch.
+	if *dedupeJSON && *jsonLayout == "arch" {
+		kingpin.Fatalf("--dedupe-json cannot be combined with --json-layout arch")
+	}
+	// Only the single product community downloads can be keyed by arch,
+	// rejected before anything is written.
+	if *jsonLayout == "arch" {
+		if *combinedJSON {
+			kingpin.Fatalf("--combined cannot be combined with --json-layout arch")
+		}
+		if *appName == "minio-enterprise" || *appName == "mc-enterprise" {
+			kingpin.Fatalf("--json-layout arch is not supported for %s", *appName)
+		}
+	}
+
+	selected, err := selectPackagers(*appName, *packager)
+	if err != nil {
+		kingpin.Fatalf(err.Error())
+	}
+	*packager = strings.Join(selected, ",")
+
+	if *archMapFile != "" {
+		if err := loadArchMaps(*archMapFile, *appName, selected); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+	}
+
+	if *reconcile {
+		problems, err := reconcileDownloads(downloadsJSONPath("release"))
+		if err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			kingpin.Fatalf("%d entries of %s do not match the release directory", len(problems), downloadsJSONPath("release"))
+		}
+		fmt.Println("All packages referenced by", downloadsJSONPath("release"), "are present")
+		return
+	}
+
+	if *feedPath != "" {
+		if err := updateFeed(*feedPath, *appName, *release); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+		fmt.Println("Added", *release, "to", *feedPath)
+		return
+	}
+
+	if *jsonOnlyTag != "" {
+		if _, _, err := releaseTagToReleaseTime(*jsonOnlyTag); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+		*release = *jsonOnlyTag
+	}
+
+	semVerTag, err := semVerRelease(*release)
+	if err != nil {
+		kingpin.Fatalf(err.Error())
+	}
+	if *printVersionInfo {
+		printVersionInfoTo(os.Stdout, *appName, *release, semVerTag)
+		return
+	}
+
+	caps := appCaps(*appName)
+	if manifest != nil {
+		caps.Packages = caps.Packages && manifest.wants("packages")
+		caps.JSON = caps.JSON && manifest.wants("json")
+	}
+	// --diff-against builds no packages and writes nothing, it only diffs
+	// the release downloads JSON.
+	if *diffAgainst != "" {
+		if !caps.JSON {
+			kingpin.Fatalf("--diff-against needs the downloads JSON, which is not generated for %s", *appName)
+		}
+		writeDownloads(semVerTag, nil, true)
+		return
+	}
+	if *jsonOnlyTag != "" {
+		caps.Packages = false
+	}
+	var built []builtPackage
+	if caps.Packages {
+		built, err = doPackage(*appName, *release, *packager)
+		if err != nil {
+			if !*ignoreMissingArch {
+				kingpin.Fatalf(err.Error())
+			} else {
+				kingpin.Errorf(err.Error())
+			}
+		}
+	}
+
+	if *perArchManifest {
+		if err = writeArchManifests(*appName, *release, built); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+	}
+
+	if *casOut != "" {
+		if err = writeCASStore(*casOut, built); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+	}
+
+	if *lint {
+		for _, b := range built {
+			if b.Packager != "deb" {
+				continue
+			}
+			warnings, err := lintDeb(b.Path)
+			if err != nil {
+				kingpin.Fatalf("unable to lint %s: %v", b.Path, err)
+			}
+			for _, w := range warnings {
+				fmt.Fprintf(os.Stderr, "lint: %s: %s\n", b.Path, w)
+			}
+		}
+	}
+
+	if *externalLint {
+		for _, b := range built {
+			if err = runExternalLint(b); err != nil {
+				kingpin.Fatalf(err.Error())
+			}
+		}
+	}
+
+	if caps.JSON {
+		writeDownloads(semVerTag, built, false)
+	}
+
+	// Written last so that it covers the downloads metadata too.
+	if *checksumsIndex {
+		if err = writeChecksumsIndex(built); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+	}
+
+	if *traceFlag {
+		printTrace(os.Stdout, built)
+	}
+
+	if *summary {
+		var jsonPath string
+		if caps.JSON {
+			jsonPath = downloadsJSONPath("release")
+		}
+		printSummary(os.Stdout, built, jsonPath)
+	}
+}
+
+// printSummary prints the --summary line for built, jsonPath is the
+// downloads metadata written by the run, if any.
+func printSummary(w io.Writer, built []builtPackage, jsonPath string) {
+	arches := make(map[string]struct{})
+	var size int64
+	for _, b := range built {
+		arches[b.Arch] = struct{}{}
+		size += b.Size
+	}
+	fmt.Fprintf(w, "Summary: %d packages built across %d arches, %d bytes total", len(built), len(arches), size)
+	if jsonPath != "" {
+		fmt.Fprintf(w, ", metadata at %s", jsonPath)
+	}
+	fmt.Fprintln(w)
+}
+
+// printVersionInfoTo prints the versions and package names derived
+// from release for --print-version-info.
+func printVersionInfoTo(w io.Writer, appName, release, semVerTag string) {
+	rtime, _, _ := releaseTagToReleaseTime(release)
+	fmt.Fprintln(w, "Release:      ", release)
+	fmt.Fprintln(w, "Release time: ", rtime.Format(time.RFC3339))
+	fmt.Fprintln(w, "SemVer:       ", semVerTag)
+	fmt.Fprintln(w, "RPM version:  ", rpmVersion(semVerTag))
+	fmt.Fprintln(w, "DEB version:  ", debVersion(semVerTag))
+	fmt.Fprintln(w, "APK version:  ", apkVersion(semVerTag))
+	for _, arch := range []string{"amd64", "arm64"} {
+		fmt.Fprintf(w, "RPM (%s):   %s-%s.%s.rpm\n", arch, packagerName(appName, "rpm"), rpmVersion(semVerTag), rpmArchMap[arch])
+		fmt.Fprintf(w, "DEB (%s):   %s_%s_%s.deb\n", arch, packagerName(appName, "deb"), debVersion(semVerTag), debArchMap[arch])
+		fmt.Fprintf(w, "APK (%s):   %s_%s_%s.apk\n", arch, packagerName(appName, "apk"), apkVersion(semVerTag), apkArchMap[arch])
+	}
+}
+
+// writeDownloads generates the downloads JSON of every channel from
+// the packages in built, in dryRun only the release channel is diffed
+// against --diff-against and nothing is written.
+func writeDownloads(semVerTag string, built []builtPackage, dryRun bool) {
+	var err error
+	if !dryRun {
+		if err = os.MkdirAll(jsonDirName(), 0o755); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+	}
+
+	var universal bool
+	if *macosUniversal && *jsonOnlyTag == "" && !dryRun {
+		if universal, err = buildUniversalMacOS(*appName, *release); err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	for _, channel := range strings.Split(*channels, ",") {
+		if dryRun && channel != "release" {
+			continue
+		}
+		var d any
+		switch *appName {
+		case "minio-enterprise", "mc-enterprise":
+			d = generateEnterpriseDownloadsJSON(semVerTag, *appName)
+		case "kubectl-minio":
+			d = generateKubectlMinioDownloadsJSON(semVerTag)
+		default:
+			d = generateDownloadsJSON(semVerTag, *appName)
+		}
+		if *combinedJSON {
+			d = generateCombinedDownloadsJSON(semVerTag, *appName)
+		}
+		dropSkippedArches(d)
+		if universal {
+			addUniversalMacOS(d)
+		}
+
+		for app, segment := range *dlPaths {
+			def, ok := dlPathSegments[app]
+			if !ok {
+				kingpin.Fatalf("unknown app %s for --dl-path", app)
+			}
+			rewriteDownloads(d, func(s string) string {
+				return strings.ReplaceAll(s, "/"+def+"/", "/"+strings.Trim(segment, "/")+"/")
+			})
+		}
+
+		if host := dlEnvHosts[*dlEnv]; host != dlEnvHosts["prod"] {
+			rewriteDownloads(d, func(s string) string {
+				s = strings.ReplaceAll(s, "://dl.minio.io/", "://"+host+"/")
+				return strings.ReplaceAll(s, "://"+dlEnvHosts["prod"]+"/", "://"+host+"/")
+			})
+		}
+
+		if channel != "release" {
+			rewriteDownloads(d, func(s string) string {
+				return strings.ReplaceAll(s, "/release/", "/"+channel+"/")
+			})
+		}
+
+		if *relativeURLs {
+			relativizeURLs(d)
+		}
+
+		if *validateURLsOffline {
+			if problems := validateURLs(d, semVerTag); len(problems) > 0 {
+				for _, p := range problems {
+					fmt.Fprintln(os.Stderr, p)
+				}
+				kingpin.Fatalf("%d invalid URLs in the %s downloads JSON", len(problems), channel)
+			}
+		}
+
+		if *includeChecksum {
+			embedChecksums(d, built)
+		}
+		if *includeInstalledSize {
+			embedInstalledSizes(d, built)
+		}
+		embedSizes(d, *appName, *release, built)
+
+		addArchNotes(d, *archNotes)
+
+		if *crlf {
+			for _, dj := range allDownloads(d) {
+				windowsCRLF(dj)
+			}
+		}
+
+		if *dedupeJSON {
+			for _, dj := range allDownloads(d) {
+				dedupeArches(dj)
+			}
+		}
+
+		if *textInstructions && channel == "release" && !dryRun {
+			if err := writeTextInstructions(d); err != nil {
+				kingpin.Fatalf(err.Error())
+			}
+		}
+
+		// latest.json points at the newest release, not a regenerated one.
+		if *latestJSON && channel == "release" && *jsonOnlyTag == "" && !dryRun {
+			if err := writeLatestJSON(*release, d); err != nil {
+				kingpin.Fatalf(err.Error())
+			}
+		}
+
+		if *checksumsJSON && channel == "release" && !dryRun {
+			if err := writeChecksumsJSON(d); err != nil {
+				kingpin.Fatalf(err.Error())
+			}
+		}
+
+		if *jsonLayout == "arch" {
+			dj, ok := d.(downloadsJSON)
+			if !ok {
+				kingpin.Fatalf("--json-layout arch is not supported for %s", *appName)
+			}
+			d = pivotByArch(dj)
+		}
+
+		buf, err := json.Marshal(&d)
+		if err != nil {
+			kingpin.Fatalf(err.Error())
+		}
+
+		if dryRun {
+			changes, err := diffDownloads(*diffAgainst, buf)
+			if err != nil {
+				kingpin.Fatalf(err.Error())
+			}
+			for _, c := range changes {
+				fmt.Println(c)
+			}
+			fmt.Printf("%d URL changes against %s\n", len(changes), *diffAgainst)
+			return
+		}
+		if *outputFormat == "yaml" {
+			if buf, err = jsonToYAML(buf); err != nil {
+				kingpin.Fatalf(err.Error())
+			}
+		}
+
+		if err = writeFileAtomic(downloadsJSONPath(channel), buf); err != nil {
+			kingpin.Fatalf("unable to write %s: %v", downloadsJSONPath(channel), err)
+		}
+		shasum := sha256.Sum256(buf)
+		if err = writeFileAtomic(downloadsJSONPath(channel)+*checksumSuffix,
+			[]byte(fmt.Sprintf("%s  %s", hex.EncodeToString(shasum[:]), filepath.Base(downloadsJSONPath(channel))))); err != nil {
+			kingpin.Fatalf("unable to write %s: %v", downloadsJSONPath(channel)+*checksumSuffix, err)
+		}
+
+		fmt.Println("Generated downloads metadata at", downloadsJSONPath(channel))
+	}
+}
+
+type releaseTmpl struct {
+	App           string
+	ReleaseDir    string
+	Binary        string
+	BinarySrc     string
+	Description   string
+	Maintainer    string
+	Vendor        string
+	Homepage      string
+	License       string
+	Section       string
+	Summary       string
+	OS            string
+	Arch          string
+	Release       string
+	SemVerRelease string
+	Epoch         string
+	Packagers     []string
+	Obsoletes     []string
+	VCSRef        string
+
+	InstallName      string
+	VersionedInstall bool
+
+	DebCompression string
+	DebTriggers    map[string][]string
+
+	Service        string
+	ServiceFile    string
+	SystemdDropins []string
+	Scripts        pkgScripts
+	DefaultScripts map[string]pkgScripts
+	DebPostRemove  string
+	OpenRCFile     string
+	Symlinks       []pkgSymlink
+	ExtraFiles     []pkgFile
+	ConfigFiles    []pkgFile
+	Signature      string
+	NoticeFile     string
+
+	DebconfTemplates string
+	DebconfConfig    string
+}
+
+// changelogSection returns the body of the markdown section whose
+// heading mentions release, up to the next heading of the same or a
+// higher level.
+func changelogSection(md, release string) (string, bool) {
+	var (
+		body  []string
+		level int
+	)
+	for _, line := range strings.Split(md, "\n") {
+		hashes := len(line) - len(strings.TrimLeft(line, "#"))
+		isHeading := hashes > 0 && strings.HasPrefix(line[hashes:], " ")
+		if level == 0 {
+			if isHeading && strings.Contains(line, release) {
+				level = hashes
+			}
+			continue
+		}
+		if isHeading && hashes <= level {
+			break
+		}
+		body = append(body, line)
+	}
+	if level == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(strings.Join(body, "\n")), true
+}
+
+// pkgSymlink is a symlink created in the package at Link pointing to
+// Target.
+type pkgSymlink struct {
+	Target string
+	Link   string
+}
+
+// parseSymlinks parses `target:linkpath` values of --symlink.
+func parseSymlinks(values []string) ([]pkgSymlink, error) {
+	links := make([]pkgSymlink, 0, len(values))
+	for _, v := range values {
+		target, link, ok := strings.Cut(v, ":")
+		if !ok || target == "" || link == "" {
+			return nil, fmt.Errorf("invalid symlink %q, expected target:linkpath", v)
+		}
+		links = append(links, pkgSymlink{Target: target, Link: link})
+	}
+	return links, nil
+}
+
+// parseDebTriggers parses `[kind:]name` values of --deb-trigger into
+// the trigger names per nfpm deb triggers key.
+func parseDebTriggers(values []string) (map[string][]string, error) {
+	kinds := map[string]bool{
+		"interest": true, "interest-await": true, "interest-noawait": true,
+		"activate": true, "activate-await": true, "activate-noawait": true,
+	}
+	triggers := make(map[string][]string)
+	for _, v := range values {
+		kind, name, ok := strings.Cut(v, ":")
+		if !ok {
+			kind, name = "activate-noawait", v
+		}
+		if !kinds[kind] || name == "" {
+			return nil, fmt.Errorf("invalid deb trigger %q, expected [kind:]name", v)
+		}
+		key := strings.ReplaceAll(kind, "-", "_")
+		triggers[key] = append(triggers[key], name)
+	}
+	return triggers, nil
+}
+
+// pkgFile is an extra file installed from Src to Dst.
+type pkgFile struct {
+	Src string
+	Dst string
+}
+
+// expandExtraFiles parses `src:dst` values of --extra-file. A src glob
+// expands to every match, installed under the dst directory at its
+// path relative to the glob's leading directory.
+func expandExtraFiles(values []string) ([]pkgFile, error) {
+	var pfiles []pkgFile
+	for _, v := range values {
+		src, dst, ok := strings.Cut(v, ":")
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid extra file %q, expected src:dst", v)
+		}
+		if !strings.ContainsAny(src, "*?[") {
+			pfiles = append(pfiles, pkgFile{Src: src, Dst: dst})
+			continue
+		}
+		matches, err := filepath.Glob(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extra file pattern %q: %w", src, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("extra file pattern %q matches no files", src)
+		}
+		base := src
+		for strings.ContainsAny(base, "*?[") {
+			base = filepath.Dir(base)
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if fi.IsDir() {
+				continue
+			}
+			rel, err := filepath.Rel(base, m)
+			if err != nil {
+				return nil, err
+			}
+			pfiles = append(pfiles, pkgFile{Src: m, Dst: path.Join(dst, filepath.ToSlash(rel))})
+		}
+	}
+	return pfiles, nil
+}
+
+// defaultsDirFiles returns the files below dir, installed at their
+// relative path under /etc/minio.
+func defaultsDirFiles(dir string) ([]pkgFile, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	var pfiles []pkgFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		pfiles = append(pfiles, pkgFile{Src: p, Dst: path.Join("/etc/minio", filepath.ToSlash(rel))})
+		return nil
+	})
+	return pfiles, err
+}
+
+// writeDebPostRemove writes to path the deb postrm deleting the
+// /etc/default/<app> environment file and the /etc/minio config
+// directory on purge, remove keeps both.
+func writeDebPostRemove(path, app string) error {
+	return writeDefaultScript(path, "postremove-deb", struct {
+		EnvFile   string
+		ConfigDir string
+	}{"/etc/default/" + app, "/etc/minio"})
+}
+
+// writeDefaultScript renders the embedded defaults/<name>.sh.tmpl
+// with data to path.
+func writeDefaultScript(path, name string, data any) error {
+	buf, err := defaultScripts.ReadFile("defaults/" + name + ".sh.tmpl")
+	if err != nil {
+		return err
+	}
+	t, err := template.New(name).Funcs(template.FuncMap{
+		"shquote": func(s string) string {
+			return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+		},
+	}).Parse(string(buf))
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err = t.Execute(&b, data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0o755)
+}
+
+// pkgMeta is the package metadata, overridable with --meta.
+type pkgMeta struct {
+	Description string            `yaml:"description"`
+	License     string            `yaml:"license"`
+	Maintainer  string            `yaml:"maintainer"`
+	Vendor      string            `yaml:"vendor"`
+	Homepage    string            `yaml:"homepage"`
+	Section     string            `yaml:"section"`
+	Summary     string            `yaml:"summary"`
+	FileModes   map[string]string `yaml:"file_modes"`
+
+	modes map[string]os.FileMode
+}
+
+// loadMeta returns the default package metadata with the fields set
+// in the YAML file at path applied on top.
+func loadMeta(path string) (pkgMeta, error) {
+	meta := pkgMeta{
+		License:    "AGPLv3",
+		Maintainer: "MinIO Development <dev@minio.io>",
+		Vendor:     "MinIO, Inc.",
+		Homepage:   "https://min.io",
+	}
+	if path == "" {
+		return meta, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+	if err = yaml.Unmarshal(buf, &meta); err != nil {
+		return meta, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	for field, v := range map[string]string{
+		"license":    meta.License,
+		"maintainer": meta.Maintainer,
+		"vendor":     meta.Vendor,
+		"homepage":   meta.Homepage,
+		"section":    meta.Section,
+		"summary":    meta.Summary,
+	} {
+		if strings.ContainsAny(v, "\r\n") {
+			return meta, fmt.Errorf("%s in %s must be a single line", field, path)
+		}
+	}
+	meta.modes = make(map[string]os.FileMode, len(meta.FileModes))
+	for dst, mode := range meta.FileModes {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0o7777 {
+			return meta, fmt.Errorf("invalid mode %q for %s in %s", mode, dst, path)
+		}
+		meta.modes[dst] = os.FileMode(m)
+	}
+	return meta, nil
+}
+
+// releaseManifest declares exactly what to build, read with
+// --manifest-in instead of inferring it from the release directory.
+type releaseManifest struct {
+	App       string            `yaml:"app"`
+	Release   string            `yaml:"release"`
+	Packagers []string          `yaml:"packagers"`
+	Binaries  map[string]string `yaml:"binaries"`
+	Outputs   []string          `yaml:"outputs"`
+}
+
+// manifest is the --manifest-in manifest, nil without one.
+var manifest *releaseManifest
+
+// manifestOutputs are the outputs a manifest may list.
+var manifestOutputs = map[string]bool{
+	"packages": true,
+	"json":     true,
+}
+
+// wants tells whether the manifest asks for output, all outputs are
+// built when it lists none.
+func (m releaseManifest) wants(output string) bool {
+	if len(m.Outputs) == 0 {
+		return true
+	}
+	for _, o := range m.Outputs {
+		if o == output {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestPackager tells whether the manifest lists pkger as a packager,
+// every packager is built without a manifest.
+func manifestPackager(pkger string) bool {
+	if manifest == nil {
+		return true
+	}
+	for _, p := range manifest.Packagers {
+		if p == pkger {
+			return true
+		}
+	}
+	return false
+}
+
+// loadManifest reads and validates the release manifest at path.
+func loadManifest(path string) (releaseManifest, error) {
+	var m releaseManifest
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err = yaml.Unmarshal(buf, &m); err != nil {
+		return m, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	if m.App == "" {
+		return m, fmt.Errorf("%s: app is required", path)
+	}
+	if _, _, err = releaseTagToReleaseTime(m.Release); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(m.Binaries) == 0 {
+		return m, fmt.Errorf("%s: at least one binary is required", path)
+	}
+	for arch, bin := range m.Binaries {
+		if _, err = os.Stat(bin); err != nil {
+			return m, fmt.Errorf("%s: binary for %s: %w", path, arch, err)
+		}
+	}
+	if len(m.Packagers) == 0 {
+		m.Packagers = []string{"deb", "rpm", "apk"}
+	}
+	for _, o := range m.Outputs {
+		if !manifestOutputs[o] {
+			return m, fmt.Errorf("%s: unknown output %q", path, o)
+		}
+	}
+	return m, nil
+}
+
+var licenseIDRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.\-]*\+?$`)
+
+// checkLicenseExpr verifies that expr looks like an SPDX license
+// expression, license ids combined with AND, OR, WITH and parentheses.
+func checkLicenseExpr(expr string) error {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr))
+	depth := 0
+	wantID := true
+	for i, tok := range tokens {
+		switch {
+		case tok == "(":
+			if !wantID {
+				return fmt.Errorf("invalid license expression %q: unexpected (", expr)
+			}
+			depth++
+		case tok == ")":
+			if wantID || depth == 0 {
+				return fmt.Errorf("invalid license expression %q: unexpected )", expr)
+			}
+			depth--
+		case tok == "AND" || tok == "OR" || tok == "WITH":
+			if wantID {
+				return fmt.Errorf("invalid license expression %q: unexpected %s", expr, tok)
+			}
+			if tok == "WITH" && (i+1 == len(tokens) || !licenseIDRegex.MatchString(tokens[i+1])) {
+				return fmt.Errorf("invalid license expression %q: WITH needs an exception id", expr)
+			}
+			wantID = true
+		default:
+			if !wantID || !licenseIDRegex.MatchString(tok) {
+				return fmt.Errorf("invalid license expression %q: unexpected %s", expr, tok)
+			}
+			wantID = false
+		}
+	}
+	if wantID || depth != 0 {
+		return fmt.Errorf("invalid license expression %q: incomplete", expr)
+	}
+	return nil
+}
+
+// yamlQuote returns s as a double-quoted YAML scalar, the JSON string
+// escapes are valid YAML escapes, so quotes and newlines in user values
+// cannot break out of the value.
+func yamlQuote(s string) string {
+	buf, _ := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(s)
+	return string(buf)
+}
+
+// indentText indents every line of s by n spaces for a YAML block
+// scalar. The indentation the lines of s have in common, not counting
+// the first line, is removed first so they line up in the block.
+func indentText(n int, s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	common := -1
+	for _, l := range lines[1:] {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if ind := len(l) - len(strings.TrimLeft(l, " \t")); common < 0 || ind < common {
+			common = ind
+		}
+	}
+	pad := strings.Repeat(" ", n)
+	for i, l := range lines {
+		if i > 0 && common > 0 && len(l) >= common {
+			l = l[common:]
+		}
+		if strings.TrimSpace(l) == "" {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = pad + strings.ReplaceAll(l, "\t", "    ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pkgInstalledSize returns the installed size in bytes declared by the
+// package at path, packagers other than deb and rpm report 0.
+func pkgInstalledSize(pkger, path string) (int64, error) {
+	switch pkger {
+	case "deb":
+		fields, err := debControlFields(path)
+		if err != nil {
+			return 0, err
+		}
+		kib, err := strconv.ParseInt(fields["Installed-Size"], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Installed-Size %q", fields["Installed-Size"])
+		}
+		return kib * 1024, nil
+	case "rpm":
+		return rpmHeaderSize(path)
+	}
+	return 0, nil
+}
+
+// debControlFields returns the fields of the control file of the deb
+// at pkgPath.
+func debControlFields(pkgPath string) (map[string]string, error) {
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := ar.NewReader(f)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control archive in %s", pkgPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !strings.HasPrefix(name, "control.tar") {
+			continue
+		}
+		tr, err := debTarReader(name, r)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			th, err := tr.Next()
+			if err == io.EOF {
+				return nil, fmt.Errorf("no control file in %s", pkgPath)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if path.Clean(th.Name) == "control" {
+				return parseControl(tr)
+			}
+		}
+	}
+}
+
+// parseControl parses the fields of a deb control file, continuation
+// lines are skipped.
+func parseControl(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok && !strings.HasPrefix(k, " ") {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	return fields, sc.Err()
+}
+
+const (
+	rpmLeadSize       = 96
+	rpmTagSize        = 1009
+	rpmTagLongSize    = 5009
+	rpmTypeInt32      = 4
+	rpmTypeInt64      = 5
+	rpmHeaderMagic    = "\x8e\xad\xe8\x01"
+	rpmIndexEntrySize = 16
+)
+
+// rpmHeaderSize returns the SIZE (or LONGSIZE) tag of the main header
+// of the rpm at path.
+func rpmHeaderSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if _, err = r.Discard(rpmLeadSize); err != nil {
+		return 0, err
+	}
+	// The signature header is padded to 8 bytes, the main header is not.
+	for i, pad := range []bool{true, false} {
+		var intro [16]byte
+		if _, err = io.ReadFull(r, intro[:]); err != nil {
+			return 0, err
+		}
+		if string(intro[:4]) != rpmHeaderMagic {
+			return 0, fmt.Errorf("invalid rpm header in %s", path)
+		}
+		nindex := int(binary.BigEndian.Uint32(intro[8:12]))
+		hsize := int(binary.BigEndian.Uint32(intro[12:16]))
+		index := make([]byte, nindex*rpmIndexEntrySize)
+		if _, err = io.ReadFull(r, index); err != nil {
+			return 0, err
+		}
+		store := make([]byte, hsize)
+		if _, err = io.ReadFull(r, store); err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			if pad && hsize%8 != 0 {
+				if _, err = r.Discard(8 - hsize%8); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		}
+		for e := 0; e < nindex; e++ {
+			entry := index[e*rpmIndexEntrySize:]
+			tag := binary.BigEndian.Uint32(entry[0:4])
+			typ := binary.BigEndian.Uint32(entry[4:8])
+			off := int(binary.BigEndian.Uint32(entry[8:12]))
+			switch {
+			case tag == rpmTagSize && typ == rpmTypeInt32 && off+4 <= hsize:
+				return int64(binary.BigEndian.Uint32(store[off:])), nil
+			case tag == rpmTagLongSize && typ == rpmTypeInt64 && off+8 <= hsize:
+				return int64(binary.BigEndian.Uint64(store[off:])), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no size tag in %s", path)
+}
+
+// lintAllowedPrefixes are the install locations packaged files may
+// live under.
+var lintAllowedPrefixes = []string{"/usr/", "/etc/", "/lib/", "/var/", "/opt/"}
+
+// lintDeb runs packaging policy checks on the deb at path and returns
+// the problems found.
+func lintDeb(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var warnings []string
+	var seenControl, seenData bool
+	r := ar.NewReader(f)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		switch {
+		case strings.HasPrefix(name, "control.tar"):
+			seenControl = true
+			tr, err := debTarReader(name, r)
+			if err != nil {
+				return nil, err
+			}
+			w, err := lintDebControl(tr)
+			if err != nil {
+				return nil, err
+			}
+			warnings = append(warnings, w...)
+		case strings.HasPrefix(name, "data.tar"):
+			seenData = true
+			tr, err := debTarReader(name, r)
+			if err != nil {
+				return nil, err
+			}
+			w, err := lintDebData(tr)
+			if err != nil {
+				return nil, err
+			}
+			warnings = append(warnings, w...)
+		}
+	}
+	if !seenControl {
+		warnings = append(warnings, "no control archive")
+	}
+	if !seenData {
+		warnings = append(warnings, "no data archive")
+	}
+	return warnings, nil
+}
+
+// debTarReader returns a tar reader for the deb member name, picking
+// the decompressor from its extension.
+func debTarReader(name string, r io.Reader) (*tar.Reader, error) {
+	switch path.Ext(name) {
+	case ".tar":
+		return tar.NewReader(r), nil
+	case ".gz":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(zr), nil
+	case ".xz":
+		zr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(zr), nil
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(zr.IOReadCloser()), nil
+	}
+	return nil, fmt.Errorf("unsupported deb member %s", name)
+}
+
+func lintDebControl(tr *tar.Reader) ([]string, error) {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return []string{"no control file"}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(hdr.Name) != "control" {
+			continue
+		}
+		fields, err := parseControl(tr)
+		if err != nil {
+			return nil, err
+		}
+		var warnings []string
+		for _, k := range []string{"Maintainer", "Description"} {
+			if fields[k] == "" {
+				warnings = append(warnings, "missing "+k+" in control")
+			}
+		}
+		// nfpm fills in a placeholder when no maintainer is set.
+		if strings.HasPrefix(fields["Maintainer"], "Unset Maintainer") {
+			warnings = append(warnings, "unset Maintainer in control")
+		}
+		return warnings, nil
+	}
+}
+
+func lintDebData(tr *tar.Reader) ([]string, error) {
+	var warnings []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return warnings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean("/" + hdr.Name)
+		if hdr.Typeflag != tar.TypeSymlink && hdr.Mode&0o002 != 0 {
+			warnings = append(warnings, name+" is world-writable")
+		}
+		allowed := false
+		for _, p := range lintAllowedPrefixes {
+			if strings.HasPrefix(name, p) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			warnings = append(warnings, name+" is outside of "+strings.Join(lintAllowedPrefixes, ", "))
+		}
+	}
+}
+
+// pkgScripts holds the paths of the package scripts found in the
+// scripts directory, upgrade scripts are only used by apk and
+// transaction scripts only by rpm.
+type pkgScripts struct {
+	PreInstall  string
+	PostInstall string
+	PreRemove   string
+	PostRemove  string
+	PreUpgrade  string
+	PostUpgrade string
+	PreTrans    string
+	PostTrans   string
+}
+
+// warnMissingShebang warns when the script at path does not start with
+// `#!`, which makes it fail when run by the package manager.
+func warnMissingShebang(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	magic := make([]byte, 2)
+	if _, err = io.ReadFull(f, magic); err != nil || string(magic) != "#!" {
+		fmt.Fprintf(os.Stderr, "warning: script %s has no shebang (#!)\n", path)
+	}
+}
+
+// defaultScripts are used for the scripts missing from --scripts-dir.
+//
+//go:embed defaults/*.tmpl
+var defaultScripts embed.FS
+
+// defaultServiceScripts returns per systemd packager the embedded
+// default postinstall and preremove of the systemd unit service for
+// those missing from s, extracted into dir. A packager specific
+// defaults/<name>-<packager>.sh.tmpl takes precedence.
+func defaultServiceScripts(s pkgScripts, service, dir string) (map[string]pkgScripts, error) {
+	defaults := make(map[string]pkgScripts)
+	for _, pkger := range sortedKeys(systemdPackagers) {
+		var d pkgScripts
+		for _, script := range []struct {
+			name string
+			path string
+			dflt *string
+		}{
+			{"postinstall", s.PostInstall, &d.PostInstall},
+			{"preremove", s.PreRemove, &d.PreRemove},
+		} {
+			if script.path != "" {
+				continue
+			}
+			name := script.name
+			if _, err := fs.Stat(defaultScripts, "defaults/"+name+"-"+pkger+".sh.tmpl"); err == nil {
+				name += "-" + pkger
+			}
+			p := filepath.Join(dir, name+".sh")
+			if err := writeDefaultScript(p, name, struct{ Service string }{service}); err != nil {
+				return defaults, err
+			}
+			*script.dflt = p
+		}
+		defaults[pkger] = d
+	}
+	return defaults, nil
+}
+
+// executableScripts copies the scripts of s into dir with mode 0755 and
+// points s at the copies, the package managers run them directly.
+func executableScripts(s *pkgScripts, dir string) error {
+	for _, p := range []*string{
+		&s.PreInstall, &s.PostInstall, &s.PreRemove, &s.PostRemove,
+		&s.PreUpgrade, &s.PostUpgrade, &s.PreTrans, &s.PostTrans,
+	} {
+		if *p == "" {
+			continue
+		}
+		buf, err := os.ReadFile(*p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.Base(*p))
+		if err = os.WriteFile(dst, buf, 0o755); err != nil {
+			return err
+		}
+		*p = dst
+	}
+	return nil
+}
+
+func findScripts(dir string) pkgScripts {
+	if dir == "" {
+		return pkgScripts{}
+	}
+	find := func(name string) string {
+		p := filepath.Join(dir, name+".sh")
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			warnMissingShebang(p)
+			return p
+		}
+		return ""
+	}
+	return pkgScripts{
+		PreInstall:  find("preinstall"),
+		PostInstall: find("postinstall"),
+		PreRemove:   find("preremove"),
+		PostRemove:  find("postremove"),
+		PreUpgrade:  find("preupgrade"),
+		PostUpgrade: find("postupgrade"),
+		PreTrans:    find("pretrans"),
+		PostTrans:   find("posttrans"),
+	}
+}
+
+const (
+	minioReleaseTagTimeLayout    = "2006-01-02T15-04-05Z"
+	minioPkgReleaseTagTimeLayout = "20060102150405"
+)
+
+// releaseTagToReleaseTime - reverse of `releaseTimeToReleaseTag()`
+func releaseTagToReleaseTime(releaseTag string) (releaseTime time.Time, fields []string, err error) {
+	fields = strings.Split(releaseTag, ".")
+	if len(fields) < 2 || len(fields) > 4 {
+		return releaseTime, nil, fmt.Errorf("%s is not a valid release tag", releaseTag)
+	}
+	if fields[0] != "RELEASE" {
+		return releaseTime, nil, fmt.Errorf("%s is not a valid release tag", releaseTag)
+	}
+	releaseTime, err = time.Parse(minioReleaseTagTimeLayout, fields[1])
+	return releaseTime, fields, err
+}
+
+// prereleaseKinds are the release tag suffixes (e.g. `.rc.1`) that
+// mark a prerelease rather than a hotfix.
+var prereleaseKinds = map[string]bool{
+	"rc":   true,
+	"beta": true,
+}
+
+func semVerRelease(release string) (string, error) {
+	rtime, fields, err := releaseTagToReleaseTime(release)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) == 4 && prereleaseKinds[fields[2]] {
+		return rtime.Format(minioPkgReleaseTagTimeLayout) + ".0.0-" + fields[2] + fields[3], nil
+	}
+	// RELEASE.<ts>.<build> carries a build number which becomes the
+	// patch version, only when asked for since the third field used
+	// to be ignored.
+	if len(fields) == 3 && *buildNumber {
+		if _, err := strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return "", fmt.Errorf("%s: build number %s is not a number", release, fields[2])
+		}
+		return rtime.Format(minioPkgReleaseTagTimeLayout) + ".0." + fields[2], nil
+	}
+	var hotfixStr string
+	if len(fields) == 4 {
+		hotfixStr = fields[2] + "." + fields[3]
+	}
+	if hotfixStr != "" {
+		return rtime.Format(minioPkgReleaseTagTimeLayout) + ".0.0." + hotfixStr, nil
+	}
+	return rtime.Format(minioPkgReleaseTagTimeLayout) + ".0.0", nil
+}
+
+// cosignBlob signs the package at path with `cosign sign-blob`,
+// writing the signature and certificate next to it.
+func cosignBlob(path string) error {
+	out, err := exec.Command("cosign", "sign-blob", "--yes",
+		"--output-signature", path+".sig",
+		"--output-certificate", path+".pem",
+		path).CombinedOutput()
+	os.Stdout.Write(out)
+	if err != nil {
+		return fmt.Errorf("cosign failed for %s: %w", path, err)
+	}
+	return nil
+}
+
+// runExternalLint runs lintian on debs and rpmlint on rpms built, a
+// tool that is not installed is skipped.
+func runExternalLint(b builtPackage) error {
+	var args []string
+	switch b.Packager {
+	case "deb":
+		args = []string{"lintian", "--fail-on", "error"}
+		if *externalLintWarnings {
+			args[2] = "error,warning"
+		}
+	case "rpm":
+		args = []string{"rpmlint"}
+		if *externalLintWarnings {
+			args = append(args, "--strict")
+		}
+	default:
+		return nil
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		fmt.Printf("skipping %s of %s, %s is not installed\n", args[0], b.Path, args[0])
+		return nil
+	}
+	out, err := exec.Command(args[0], append(args[1:], b.Path)...).CombinedOutput()
+	os.Stdout.Write(out)
+	if err != nil {
+		return fmt.Errorf("%s failed for %s: %w", args[0], b.Path, err)
+	}
+	return nil
+}
+
+// runPostHook runs the --post-hook command for the artifact at path.
+func runPostHook(path string) error {
+	if *postHook == "" {
+		return nil
+	}
+	htmpl, err := template.New("post-hook").Parse(*postHook)
+	if err != nil {
+		return err
+	}
+	var cmd bytes.Buffer
+	if err = htmpl.Execute(&cmd, struct{ Path string }{path}); err != nil {
+		return err
+	}
+	out, err := exec.Command("sh", "-c", cmd.String()).CombinedOutput()
+	os.Stdout.Write(out)
+	if err != nil {
+		return fmt.Errorf("post-hook %q failed for %s: %w", cmd.String(), path, err)
+	}
+	return nil
+}
+
+// debVersion returns the deb package version for semVerTag, a
+// prerelease uses `~` so that it sorts before the final release.
+func debVersion(semVerTag string) string {
+	return strings.Replace(semVerTag, "-", "~", 1)
+}
+
+// rpmVersion returns the rpm package version-release for semVerTag, a
+// prerelease gets a `0.<prerelease>` release so that it sorts before
+// the final release `1`.
+func rpmVersion(semVerTag string) string {
+	if version, pre, ok := strings.Cut(semVerTag, "-"); ok {
+		return version + "-0." + pre
+	}
+	return semVerTag + "-1"
+}
+
+// apkPublicKey is the public half of --apk-sign-key, named like the
+// key file apk looks up in /etc/apk/keys.
+type apkPublicKey struct {
+	name string
+	pem  []byte
+}
+
+// loadAPKPublicKey derives the public key of the RSA private key at
+// path, named `minio@min.io-<8 hex of its fingerprint>.rsa.pub`.
+func loadAPKPublicKey(path string) (apkPublicKey, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return apkPublicKey{}, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return apkPublicKey{}, fmt.Errorf("no PEM key in %s", path)
+	}
+	var key any
+	if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return apkPublicKey{}, fmt.Errorf("unable to parse %s: %w", path, err)
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return apkPublicKey{}, fmt.Errorf("%s is not an RSA key", path)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		return apkPublicKey{}, err
+	}
+	sum := sha256.Sum256(der)
+	return apkPublicKey{
+		name: "minio@min.io-" + hex.EncodeToString(sum[:4]) + ".rsa.pub",
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+	}, nil
+}
+
+// packageEpoch returns the package epoch for semVerTag following the
+// `[epoch:]version` prev, bumped when semVerTag sorts lower than the
+// previous version so that package managers still see an upgrade.
+func packageEpoch(semVerTag, prev string) (string, error) {
+	if prev == "" {
+		return "", nil
+	}
+	epoch := 0
+	if e, v, ok := strings.Cut(prev, ":"); ok {
+		n, err := strconv.Atoi(e)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("invalid epoch in --prev-version %s", prev)
+		}
+		epoch, prev = n, v
+	}
+	if compareVersions(semVerTag, prev) < 0 {
+		epoch++
+		fmt.Fprintf(os.Stderr, "warning: version %s sorts lower than the previous %s, bumping the epoch to %d\n", semVerTag, prev, epoch)
+	}
+	if epoch == 0 {
+		return "", nil
+	}
+	return strconv.Itoa(epoch), nil
+}
+
+// compareVersions compares the `x.y.z[-pre]` versions a and b
+// numerically, a prerelease sorts before its final version.
+func compareVersions(a, b string) int {
+	av, apre, _ := strings.Cut(a, "-")
+	bv, bpre, _ := strings.Cut(b, "-")
+	as, bs := strings.Split(av, "."), strings.Split(bv, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	}
+	return strings.Compare(apre, bpre)
+}
+
+// apkVersion returns the apk package version for semVerTag, apk marks
+// a prerelease with `_`.
+func apkVersion(semVerTag string) string {
+	return strings.Replace(semVerTag, "-", "_", 1)
+}
+
+// verifyVersion checks that the binary at path reports release in its
+// `--version` output, binaries not runnable on this host are skipped.
+func verifyVersion(path, arch, release string) error {
+	if *osName != runtime.GOOS || arch != runtime.GOARCH {
+		fmt.Printf("skipping version check of %s, not runnable on %s/%s\n", path, runtime.GOOS, runtime.GOARCH)
+		return nil
+	}
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unable to run %s --version: %w", path, err)
+	}
+	if !bytes.Contains(out, []byte(release)) {
+		return fmt.Errorf("%s reports version %q, expected %s", path, strings.TrimSpace(string(out)), release)
+	}
+	return nil
+}
+
+// builtPackage describes a package produced by doPackage.
+type builtPackage struct {
+	Packager string
+	Arch     string
+	Path     string
+	Size     int64
+	SHA256   string
+
+	// InstalledSize is the installed size in bytes the package
+	// declares, deb declares it in KiB.
+	InstalledSize int64
+
+	// Time spent rendering the nfpm config for the arch, building the
+	// package and writing its checksum.
+	Render   time.Duration
+	Package  time.Duration
+	Checksum time.Duration
+}
+
+// printTrace prints the time spent on each step per arch and packager.
+func printTrace(w io.Writer, built []builtPackage) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ARCH\tPACKAGER\tRENDER\tPACKAGE\tCHECKSUM\tINSTALLED SIZE")
+	for _, b := range built {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n", b.Arch, b.Packager, b.Render, b.Package, b.Checksum, b.InstalledSize)
+	}
+	tw.Flush()
+}
+
+// retryDelay is the backoff unit between --pkg-retries attempts.
+var retryDelay = time.Second
+
+// packageWithRetries builds the package described by info into
+// tgtPath, retrying up to --pkg-retries times on transient errors, and
+// returns the sha256 of the package.
+func packageWithRetries(pkg nfpm.Packager, info *nfpm.Info, tgtPath string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		sum, err := packageOnce(pkg, info, tgtPath)
+		if err == nil || attempt >= *pkgRetries || !isTransient(err) {
+			return sum, err
+		}
+		fmt.Fprintf(os.Stderr, "warning: building %s failed: %v, retrying\n", tgtPath, err)
+		time.Sleep(time.Duration(attempt+1) * retryDelay)
+	}
+}
+
+func packageOnce(pkg nfpm.Packager, info *nfpm.Info, tgtPath string) ([]byte, error) {
+	f, err := os.Create(tgtPath)
+	if err != nil {
+		return nil, err
+	}
+	sh := sha256.New()
+	err = pkg.Package(info, io.MultiWriter(f, sh))
+	_ = f.Close()
+	if err != nil {
+		os.Remove(tgtPath)
+		return nil, err
+	}
+	return sh.Sum(nil), nil
+}
+
+// isTransient reports whether err is a filesystem error worth retrying.
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EBUSY, syscall.EAGAIN, syscall.EINTR, syscall.ETXTBSY} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceBinary returns the path of the release binary of appName
+// packaged for arch.
+func sourceBinary(appName, release, arch string) string {
+	if manifest != nil {
+		return manifest.Binaries[arch]
+	}
+	return platformBinary(appName, release, *osName, arch)
+}
+
+// platformBinary returns the path of the release binary for goos and
+// arch in the release directory.
+func platformBinary(appName, release, goos, arch string) string {
+	name := binaryName(appName) + "." + release
+	if *archSuffixSource {
+		name += "-" + arch
+	}
+	return filepath.Join(releaseDirName(), goos+"-"+arch, name)
+}
+
+// buildUniversalMacOS merges the darwin amd64 and arm64 binaries into
+// darwin-universal with lipo. It reports whether both binaries exist,
+// the merge is skipped with a warning when lipo is not installed.
+func buildUniversalMacOS(appName, release string) (bool, error) {
+	var srcs []string
+	for _, arch := range []string{"amd64", "arm64"} {
+		src := platformBinary(appName, release, "darwin", arch)
+		if _, err := os.Stat(src); err != nil {
+			return false, nil
+		}
+		srcs = append(srcs, src)
+	}
+	lipo, err := exec.LookPath("lipo")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: lipo not found, not merging the universal macOS binary")
+		return false, nil
+	}
+	dst := platformBinary(appName, release, "darwin", "universal")
+	if err = os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return true, err
+	}
+	if out, err := exec.Command(lipo, append([]string{"-create", "-output", dst}, srcs...)...).CombinedOutput(); err != nil {
+		return true, fmt.Errorf("lipo failed: %w: %s", err, out)
+	}
+	sum, err := sha256File(dst)
+	if err != nil {
+		return true, err
+	}
+	return true, writeFileAtomic(dst+*checksumSuffix, []byte(fmt.Sprintf("%s  %s", sum, binaryName(appName))))
+}
+
+// addUniversalMacOS adds a universal entry to every macOS product of d
+// with both an amd64 and an arm64 entry, pointing at darwin-universal.
+func addUniversalMacOS(d any) {
+	for _, dj := range allDownloads(d) {
+		for _, arches := range dj.MacOS {
+			arm, ok := arches["arm64"]
+			if _, amd := arches["amd64"]; !ok || !amd || arm.Bin == nil {
+				continue
+			}
+			// Only the binary is universal, the rest of the arm64 entry,
+			// e.g. Homebrew, does not apply.
+			bin := *arm.Bin
+			for _, s := range []*string{&bin.Download, &bin.Checksum, &bin.Text} {
+				*s = strings.ReplaceAll(*s, "/darwin-arm64/", "/darwin-universal/")
+			}
+			arches["universal"] = downloadJSON{Bin: &bin}
+		}
+	}
+}
+
+// writeChecksumsIndex writes checksums-index.json into the release
+// directory, mapping the relative path of every artifact in it to its
+// sha256. Digests of the packages in built are reused.
+func writeChecksumsIndex(built []builtPackage) error {
+	known := make(map[string]string, len(built))
+	for _, b := range built {
+		known[filepath.Clean(b.Path)] = b.SHA256
+	}
+	root := releaseDirName()
+	index := make(map[string]string)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		name := d.Name()
+		if strings.HasSuffix(name, *checksumSuffix) || name == "SHA256SUMS" || name == "checksums-index.json" {
+			return nil
+		}
+		sum, ok := known[filepath.Clean(p)]
+		if !ok {
+			if sum, err = sha256File(p); err != nil {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		index[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(root, "checksums-index.json"), buf)
+}
+
+// writeArchManifests writes a SHA256SUMS into every <os>-<arch>
+// directory with packages in built, listing those packages and the
+// release binary.
+func writeArchManifests(appName, release string, built []builtPackage) error {
+	sums := make(map[string]map[string]string)
+	for _, b := range built {
+		dir := filepath.Dir(b.Path)
+		if _, ok := sums[dir]; !ok {
+			sums[dir] = make(map[string]string)
+			bin := sourceBinary(appName, release, b.Arch)
+			sum, err := sha256File(bin)
+			if err != nil {
+				return err
+			}
+			sums[dir][filepath.Base(bin)] = sum
+		}
+		sums[dir][filepath.Base(b.Path)] = b.SHA256
+	}
+	for dir, files := range sums {
+		var b strings.Builder
+		for _, name := range sortedKeys(files) {
+			fmt.Fprintf(&b, "%s  %s\n", files[name], name)
+		}
+		if err := writeFileAtomic(filepath.Join(dir, "SHA256SUMS"), []byte(b.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// casObject is the metadata stored next to a package in the
+// content-addressed store.
+type casObject struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Packager string `json:"packager"`
+	Arch     string `json:"arch"`
+	Size     int64  `json:"size"`
+}
+
+// writeCASStore copies the packages in built into dir named by their
+// sha256, along with a `<sha256>.json` carrying their conventional
+// name. Objects already in the store are not copied again.
+func writeCASStore(dir string, built []builtPackage) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, b := range built {
+		obj := filepath.Join(dir, b.SHA256)
+		if _, err := os.Stat(obj); errors.Is(err, os.ErrNotExist) {
+			if err = copyFileAtomic(b.Path, obj); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(releaseDirName(), b.Path)
+		if err != nil {
+			return err
+		}
+		buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(casObject{
+			Name:     filepath.Base(b.Path),
+			Path:     filepath.ToSlash(rel),
+			Packager: b.Packager,
+			Arch:     b.Arch,
+			Size:     b.Size,
+		})
+		if err != nil {
+			return err
+		}
+		if err = writeFileAtomic(obj+".json", buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileAtomic copies src to dst through a temporary file, so dst
+// is either complete or missing.
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = io.Copy(f, in); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Chmod(tmp, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// pkgExt returns the extension of the package file at path, including
+// the `.pkg.tar` of Arch Linux packages.
+func pkgExt(path string) string {
+	if strings.HasSuffix(path, ".pkg.tar.zst") {
+		return ".pkg.tar.zst"
+	}
+	return filepath.Ext(path)
+}
+
+// upToDate reports whether the package at tgtPath and its checksum
+// file are newer than the input binary src, returning the package as
+// previously built if so.
+func upToDate(src, tgtPath string) (builtPackage, bool) {
+	sfi, err := os.Stat(src)
+	if err != nil {
+		return builtPackage{}, false
+	}
+	tfi, err := os.Stat(tgtPath)
+	if err != nil || !tfi.ModTime().After(sfi.ModTime()) {
+		return builtPackage{}, false
+	}
+	buf, err := os.ReadFile(tgtPath + *checksumSuffix)
+	if err != nil {
+		return builtPackage{}, false
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) == 0 {
+		return builtPackage{}, false
+	}
+	return builtPackage{Path: tgtPath, Size: tfi.Size(), SHA256: fields[0]}, true
+}
+
+// nolint:funlen
+func doPackage(appName, release, packager string) ([]builtPackage, error) {
+	var built []builtPackage
+
+	mtmpl, err := template.New("minio").Funcs(template.FuncMap{
+		"base":    filepath.Base,
+		"systemd": func(pkger string) bool { return systemdPackagers[pkger] },
+		"indent":  indentText,
+		"quote":   yamlQuote,
+		// Arch Linux has /lib symlinked to /usr/lib, packages must not
+		// install below /lib.
+		"unitDir": func(pkger string) string {
+			dir := strings.TrimSuffix(*systemdDir, "/")
+			if pkger == "archlinux" && dir == "/lib/systemd/system" {
+				return "/usr/lib/systemd/system"
+			}
+			return dir
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return built, err
+	}
+
+	var buildsRPM bool
+	for _, pkger := range strings.Split(packager, ",") {
+		buildsRPM = buildsRPM || pkger == "rpm"
+		if _, err := nfpm.Get(pkger); err != nil {
+			return built, fmt.Errorf("packager %s not available: %w", pkger, err)
+		}
+		if *osName != "linux" && linuxOnlyPackagers[pkger] {
+			return built, fmt.Errorf("packager %s does not support os %s", pkger, *osName)
+		}
+		if algos, ok := compressionAlgos[pkger]; ok && !algos[compressionAlgo(pkger)] {
+			return built, fmt.Errorf("compression %q is not supported for %s packages", compressionAlgo(pkger), pkger)
+		}
+	}
+	if *compressionLevel != 0 {
+		if !buildsRPM {
+			return built, errors.New("--compression-level only applies to rpm packages, none are built")
+		}
+		levels, ok := compressionLevels[compressionAlgo("rpm")]
+		if !ok {
+			return built, fmt.Errorf("compression %q does not take a level", compressionAlgo("rpm"))
+		}
+		if *compressionLevel < levels[0] || *compressionLevel > levels[1] {
+			return built, fmt.Errorf("compression level %d is out of range %d-%d for %s", *compressionLevel, levels[0], levels[1], compressionAlgo("rpm"))
+		}
+	}
+
+	meta, err := loadMeta(*metaFile)
+	if err != nil {
+		return built, err
+	}
+	// Only --license is an SPDX expression, --meta licenses are free
+	// form like "GNU AGPL v3".
+	if *license != "" {
+		if err = checkLicenseExpr(*license); err != nil {
+			return built, err
+		}
+		meta.License = *license
+	}
+
+	var changes string
+	if *changelogMD != "" {
+		buf, err := os.ReadFile(*changelogMD)
+		if err != nil {
+			return built, err
+		}
+		section, ok := changelogSection(string(buf), release)
+		if !ok {
+			return built, fmt.Errorf("no section for %s found in %s", release, *changelogMD)
+		}
+		changes = section
+		if meta.Description != "" {
+			fmt.Fprintf(os.Stderr, "warning: the description of %s is replaced by the %s section of %s\n", *metaFile, release, *changelogMD)
+		}
+	}
+
+	configFiles, err := defaultsDirFiles(*defaultsDir)
+	if err != nil {
+		return built, err
+	}
+
+	scripts := findScripts(*scriptsDir)
+	tmpDir, err := os.MkdirTemp("", "pkger-scripts")
+	if err != nil {
+		return built, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err = executableScripts(&scripts, tmpDir); err != nil {
+		return built, err
+	}
+	// The configuration is kept on remove, the deb postrm deletes it
+	// on purge.
+	var debPostRemove string
+	if len(configFiles) > 0 {
+		if scripts.PostRemove != "" {
+			fmt.Fprintf(os.Stderr, "warning: %s replaces the deb postrm, /etc/minio and /etc/default/%s are not deleted on purge\n", filepath.Join(*scriptsDir, "postremove.sh"), packageName(appName))
+		} else {
+			debPostRemove = filepath.Join(tmpDir, "postremove-deb.sh")
+			if err = writeDebPostRemove(debPostRemove, packageName(appName)); err != nil {
+				return built, err
+			}
+		}
+	}
+
+	debTriggers, err := parseDebTriggers(*debTriggerFlags)
+	if err != nil {
+		return built, err
+	}
+
+	symlinks, err := parseSymlinks(*symlinkFlags)
+	if err != nil {
+		return built, err
+	}
+
+	extraFiles, err := expandExtraFiles(*extraFileFlags)
+	if err != nil {
+		return built, err
+	}
+
+	service := serviceName(appName)
+	svcFile := *serviceFile
+	if svcFile == "" {
+		svcFile = service
+	}
+	needsUnit := false
+	for _, pkger := range strings.Split(packager, ",") {
+		needsUnit = needsUnit || systemdPackagers[pkger]
+	}
+	if service != "" && needsUnit {
+		if _, err := os.Stat(svcFile); err != nil {
+			return built, fmt.Errorf("service file %s for the systemd unit of %s not found: %w", svcFile, appName, err)
+		}
+	}
+	// Only the packages shipping the unit get the default scripts
+	// managing it.
+	var defaults map[string]pkgScripts
+	if service != "" && !*noDefaultScripts {
+		if defaults, err = defaultServiceScripts(scripts, service, tmpDir); err != nil {
+			return built, err
+		}
+	}
+
+	var apkKey apkPublicKey
+	if *apkSignKey != "" {
+		if apkKey, err = loadAPKPublicKey(*apkSignKey); err != nil {
+			return built, err
+		}
+	}
+
+	semVerTag, err := semVerRelease(release)
+	if err != nil {
+		return built, err
+	}
+	epoch, err := packageEpoch(semVerTag, *prevVersion)
+	if err != nil {
+		return built, err
+	}
+	arches := platformArches(appName, *osName)
+	if len(arches) == 0 {
+		arches = platformArches(appName, "linux")
+	}
+	for _, arch := range arches {
+		if optionalArches[arch] {
+			if _, err = os.Stat(sourceBinary(appName, release, arch)); errors.Is(err, fs.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "warning: no %s binary at %s, skipping %s\n", arch, sourceBinary(appName, release, arch), arch)
+				skippedArches[arch] = true
+				continue
+			}
+		}
+
+		if *verifyBinaryVersion {
+			if err = verifyVersion(sourceBinary(appName, release, arch), arch, release); err != nil {
+				return built, err
+			}
+		}
+
+		renderStart := time.Now()
 		var buf bytes.Buffer
 		err = mtmpl.Execute(&buf, releaseTmpl{
-			App: func() string {
-				if appName == "minio-enterprise" {
-					return "minio"
-				}
-				if appName == "mc" || appName == "mc-enterprise" {
-					return "mcli"
-				}
-				return appName
-			}(),
+			App:        packageName(appName),
 			ReleaseDir: releaseDirName(),
-			Binary: func() string {
-				if appName == "minio-enterprise" {
-					return "minio"
+			Binary:     binaryName(appName),
+			BinarySrc:  sourceBinary(appName, release, arch),
+			// --changelog-md, then --meta, then the built-in description.
+			Description: func() string {
+				if changes != "" {
+					return changes
 				}
-				if appName == "mc-enterprise" {
-					return "mc"
+				if meta.Description != "" {
+					return meta.Description
 				}
-				return appName
-			}(),
-			Description: func() string {
 				if appName == "minio-enterprise" {
 					return `MinIO is a High Performance Object Store.
-  It is API compatible with Amazon S3 cloud storage service. Use MinIO to build
-  high performance infrastructure for machine learning, analytics and application
-  data workloads.`
+It is API compatible with Amazon S3 cloud storage service. Use MinIO to build
+high performance infrastructure for machine learning, analytics and application
+data workloads.`
 				}
 				if appName == "mc" || appName == "mc-enterprise" {
 					return `MinIO Client for cloud storage and filesystems`
 				}
+				if appName == "sidekick" {
+					return `High-performance sidecar load-balancer for MinIO deployments`
+				}
+				if appName == "kubectl-minio" {
+					return `kubectl plugin to deploy and manage the MinIO Operator and tenants`
+				}
 				return `MinIO is a High Performance Object Storage released under AGPLv3.
-  It is API compatible with Amazon S3 cloud storage service. Use MinIO to build
-  high performance infrastructure for machine learning, analytics and application
-  data workloads.`
+It is API compatible with Amazon S3 cloud storage service. Use MinIO to build
+high performance infrastructure for machine learning, analytics and application
+data workloads.`
 			}(),
-			OS:            "linux",
+			Maintainer:    meta.Maintainer,
+			Vendor:        meta.Vendor,
+			Homepage:      meta.Homepage,
+			License:       meta.License,
+			Section:       meta.Section,
+			Summary:       meta.Summary,
+			OS:            *osName,
 			Arch:          arch,
 			Release:       release,
 			SemVerRelease: semVerTag,
+			Epoch:         epoch,
+			Packagers:     strings.Split(packager, ","),
+			Obsoletes:     *obsoletes,
+			VCSRef:        *vcsRef,
+
+			InstallName: func() string {
+				if *installName != "" {
+					return *installName
+				}
+				return packageName(appName)
+			}(),
+			VersionedInstall: *versionedInstall,
+
+			DebCompression: *debCompression,
+			DebTriggers:    debTriggers,
+
+			Service:        service,
+			ServiceFile:    svcFile,
+			SystemdDropins: *systemdDropins,
+			Scripts:        scripts,
+			DefaultScripts: defaults,
+			DebPostRemove:  debPostRemove,
+			OpenRCFile:     *openrcFile,
+			Symlinks:       symlinks,
+			ExtraFiles:     extraFiles,
+			ConfigFiles:    configFiles,
+			Signature:      *attachSig,
+			NoticeFile:     *noticeFile,
+
+			DebconfTemplates: *debconfTemplates,
+			DebconfConfig:    *debconfConfig,
 		})
 		if err != nil {
-			return err
+			return built, err
 		}
 
 		config, err := nfpm.Parse(&buf)
 		if err != nil {
-			return err
+			return built, err
 		}
+		renderTook := time.Since(renderStart)
 
 		for _, pkger := range strings.Split(packager, ",") {
 			info, err := config.Get(pkger)
 			if err != nil {
-				return err
+				return built, err
+			}
+
+			info.Name = packagerName(appName, pkger)
+
+			for _, c := range info.Contents {
+				if mode, ok := meta.modes[c.Destination]; ok {
+					if c.FileInfo == nil {
+						c.FileInfo = &files.ContentFileInfo{}
+					}
+					c.FileInfo.Mode = mode
+				}
+			}
+
+			if *sourceDateEpoch != 0 {
+				mtime := time.Unix(*sourceDateEpoch, 0).UTC()
+				info.MTime = mtime
+				for _, c := range info.Contents {
+					if c.FileInfo == nil {
+						c.FileInfo = &files.ContentFileInfo{}
+					}
+					c.FileInfo.MTime = mtime
+				}
+			}
+
+			if a, ok := pkgArchMaps[pkger][arch]; ok {
+				info.Arch = a
+			}
+
+			if _, ok := compressionAlgos[pkger]; ok {
+				algo := compressionAlgo(pkger)
+				switch pkger {
+				case "deb":
+					info.Deb.Compression = algo
+				case "rpm":
+					info.RPM.Compression = algo
+					if *compressionLevel != 0 {
+						info.RPM.Compression += ":" + strconv.Itoa(*compressionLevel)
+					}
+				}
+			}
+
+			if *signKey != "" {
+				switch pkger {
+				case "rpm":
+					info.RPM.Signature.KeyFile = *signKey
+					info.RPM.Signature.KeyPassphrase = *signKeyPassphrase
+				case "deb":
+					info.Deb.Signature.KeyFile = *signKey
+					info.Deb.Signature.KeyPassphrase = *signKeyPassphrase
+					info.Deb.Signature.Type = *debSignatureType
+				}
+			}
+			if apkKey.name != "" && pkger == "apk" {
+				info.APK.Signature.KeyFile = *apkSignKey
+				info.APK.Signature.KeyName = apkKey.name
 			}
 
 			info = nfpm.WithDefaults(info)
+			if pkger == "rpm" && info.Prerelease != "" {
+				info.Release = "0." + info.Prerelease
+				info.Prerelease = ""
+			}
 
 			if err = nfpm.Validate(info); err != nil {
 				if *ignoreMissingArch {
 					continue
 				}
-				return err
+				return built, err
 			}
 
 			fmt.Printf("using %s packager...\n", pkger)
 			pkg, err := nfpm.Get(pkger)
 			if err != nil {
-				return err
+				return built, err
 			}
 
 			releasePkg := pkg.ConventionalFileName(info)
-			tgtPath := filepath.Join(releaseDirName(), "linux-"+arch, releasePkg)
-			f, err := os.Create(tgtPath)
-			if err != nil {
-				return err
-			}
+			tgtPath := filepath.Join(releaseDirName(), *osName+"-"+arch, releasePkg)
 
-			{
-				curDir, err := os.Getwd()
-				if err != nil {
-					return err
+			if *incremental {
+				src := sourceBinary(appName, release, arch)
+				if b, ok := upToDate(src, tgtPath); ok {
+					fmt.Printf("up to date: %s\n", tgtPath)
+					b.Packager, b.Arch, b.Render = pkger, arch, renderTook
+					built = append(built, b)
+					continue
 				}
+			}
 
-				_ = os.Chdir(filepath.Dir(tgtPath))
-				_ = os.Remove(func() string {
-					if appName == "minio-enterprise" {
-						return "minio"
+			var prevShasum string
+			if *warnCollisions {
+				if buf, err := os.ReadFile(tgtPath + *checksumSuffix); err == nil {
+					if fields := strings.Fields(string(buf)); len(fields) > 0 {
+						prevShasum = fields[0]
 					}
-					return appName
-				}() + filepath.Ext(tgtPath))
-				_ = os.Symlink(releasePkg, func() string {
-					if appName == "minio-enterprise" {
-						return "minio"
-					}
-					return appName
-				}()+filepath.Ext(tgtPath))
-				_ = os.Chdir(curDir)
+				}
 			}
 
-			sh := sha256.New()
+			// A manifest may point at binaries outside the release
+			// directory, the package directory does not exist then.
+			if err = os.MkdirAll(filepath.Dir(tgtPath), 0o755); err != nil {
+				return built, err
+			}
+			link := filepath.Join(filepath.Dir(tgtPath), func() string {
+				if appName == "minio-enterprise" {
+					return "minio"
+				}
+				return appName
+			}()+pkgExt(tgtPath))
+			if err = os.Remove(link); err != nil && !os.IsNotExist(err) {
+				return built, err
+			}
+			if err = os.Symlink(releasePkg, link); err != nil {
+				return built, err
+			}
 
 			info.Target = tgtPath
-			err = pkg.Package(info, io.MultiWriter(f, sh))
-			_ = f.Close()
+			packageStart := time.Now()
+			tgtShasum, err := packageWithRetries(pkg, info, tgtPath)
+			packageTook := time.Since(packageStart)
 			if err != nil {
-				os.Remove(tgtPath)
-				return err
+				return built, err
 			}
 
-			tgtShasum := sh.Sum(nil)
-			tgtPathShasum := tgtPath + ".sha256sum"
+			if prevShasum != "" && prevShasum != hex.EncodeToString(tgtShasum) {
+				fmt.Fprintf(os.Stderr, "warning: %s was rebuilt with a different checksum (was %s, now %s)\n",
+					tgtPath, prevShasum, hex.EncodeToString(tgtShasum))
+			}
+			tgtPathShasum := tgtPath + *checksumSuffix
+			checksumStart := time.Now()
 			if err = os.WriteFile(tgtPathShasum, []byte(fmt.Sprintf("%s  %s", hex.EncodeToString(tgtShasum), releasePkg)), 0o644); err != nil {
 				os.Remove(tgtPath)
-				return err
+				return built, err
 			}
+			checksumTook := time.Since(checksumStart)
 			fmt.Printf("created package: %s\n", tgtPath)
+
+			fi, err := os.Stat(tgtPath)
+			if err != nil {
+				return built, err
+			}
+			installedSize, err := pkgInstalledSize(pkger, tgtPath)
+			if err != nil {
+				return built, fmt.Errorf("unable to read the installed size of %s: %w", tgtPath, err)
+			}
+			built = append(built, builtPackage{
+				Packager: pkger,
+				Arch:     arch,
+				Path:     tgtPath,
+				Size:     fi.Size(),
+				SHA256:   hex.EncodeToString(tgtShasum),
+				Render:   renderTook,
+				Package:  packageTook,
+				Checksum: checksumTook,
+
+				InstalledSize: installedSize,
+			})
+
+			if apkKey.name != "" && pkger == "apk" {
+				if err = os.WriteFile(filepath.Join(filepath.Dir(tgtPath), apkKey.name), apkKey.pem, 0o644); err != nil {
+					return built, err
+				}
+			}
+
+			if *digestFile || *cosign {
+				if err = os.WriteFile(tgtPath+".digest", []byte("sha256:"+hex.EncodeToString(tgtShasum)+"\n"), 0o644); err != nil {
+					return built, err
+				}
+			}
+			if *cosign {
+				if err = cosignBlob(tgtPath); err != nil {
+					return built, err
+				}
+			}
+
+			if err = runPostHook(tgtPath); err != nil {
+				return built, err
+			}
+			if *postHookChecksums {
+				if err = runPostHook(tgtPathShasum); err != nil {
+					return built, err
+				}
+			}
 		}
 	}
 
-	return nil
+	return built, nil
 }
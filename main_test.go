@@ -0,0 +1,4502 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/blakesmith/ar"
+	"github.com/goreleaser/nfpm/v2"
+	"github.com/goreleaser/nfpm/v2/files"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMain(m *testing.M) {
+	// runPkger re-executes the test binary as pkger.
+	if os.Getenv("PKGER_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	// Apply the flag defaults, tests override the flags they exercise.
+	if _, err := app.Parse(nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSemVerRelease(t *testing.T) {
+	defer func(v bool) { *buildNumber = v }(*buildNumber)
+
+	testCases := []struct {
+		release     string
+		buildNumber bool
+		want        string
+		wantErr     bool
+	}{
+		{"RELEASE.2024-06-01T00-00-00Z", false, "20240601000000.0.0", false},
+		{"RELEASE.2024-06-01T00-00-00Z.3", false, "20240601000000.0.0", false},
+		{"RELEASE.2024-06-01T00-00-00Z.3", true, "20240601000000.0.3", false},
+		{"RELEASE.2024-06-01T00-00-00Z.x", true, "", true},
+		{"RELEASE.2024-06-01T00-00-00Z.hotfix.abcdef", false, "20240601000000.0.0.hotfix.abcdef", false},
+		{"RELEASE.2024-06-01T00-00-00Z.rc.1", false, "20240601000000.0.0-rc1", false},
+		{"RELEASE.2024-06-01T00-00-00Z.beta.2", false, "20240601000000.0.0-beta2", false},
+		{"RELEASE", false, "", true},
+		{"EDGE.2024-06-01T00-00-00Z", false, "", true},
+		{"RELEASE.2024-06-01", false, "", true},
+		{"RELEASE.2024-06-01T00-00-00Z.a.b.c", false, "", true},
+	}
+	for _, tc := range testCases {
+		*buildNumber = tc.buildNumber
+		got, err := semVerRelease(tc.release)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("semVerRelease(%q) error = %v, want error %v", tc.release, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("semVerRelease(%q) = %q, want %q", tc.release, got, tc.want)
+		}
+	}
+}
+
+func TestPackageVersions(t *testing.T) {
+	testCases := []struct {
+		semVer string
+		rpm    string
+		deb    string
+		apk    string
+	}{
+		{"20240601000000.0.0", "20240601000000.0.0-1", "20240601000000.0.0", "20240601000000.0.0"},
+		{"20240601000000.0.0-rc1", "20240601000000.0.0-0.rc1", "20240601000000.0.0~rc1", "20240601000000.0.0_rc1"},
+		{"20240601000000.0.0-beta2", "20240601000000.0.0-0.beta2", "20240601000000.0.0~beta2", "20240601000000.0.0_beta2"},
+	}
+	for _, tc := range testCases {
+		if got := rpmVersion(tc.semVer); got != tc.rpm {
+			t.Errorf("rpmVersion(%q) = %q, want %q", tc.semVer, got, tc.rpm)
+		}
+		if got := debVersion(tc.semVer); got != tc.deb {
+			t.Errorf("debVersion(%q) = %q, want %q", tc.semVer, got, tc.deb)
+		}
+		if got := apkVersion(tc.semVer); got != tc.apk {
+			t.Errorf("apkVersion(%q) = %q, want %q", tc.semVer, got, tc.apk)
+		}
+	}
+}
+
+func TestPrereleaseOrdering(t *testing.T) {
+	const rc, final = "20240601000000.0.0-rc1", "20240601000000.0.0"
+	if compareVersions(rc, final) >= 0 {
+		t.Errorf("%s does not sort before %s", rc, final)
+	}
+	if compareVersions("20240601000000.0.0-beta2", rc) >= 0 {
+		t.Errorf("beta does not sort before rc")
+	}
+
+	// dpkg is the authority on deb version ordering.
+	dpkg, err := exec.LookPath("dpkg")
+	if err != nil {
+		t.Skip("dpkg not installed")
+	}
+	if err = exec.Command(dpkg, "--compare-versions", debVersion(rc), "lt", debVersion(final)).Run(); err != nil {
+		t.Errorf("dpkg does not order %s before %s", debVersion(rc), debVersion(final))
+	}
+}
+
+func TestPivotByArch(t *testing.T) {
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {
+				"amd64": {Bin: &dlInfo{Download: "linux-amd64/minio"}},
+				"arm64": {Bin: &dlInfo{Download: "linux-arm64/minio"}},
+			},
+		},
+		MacOS: map[string]map[string]downloadJSON{
+			"MinIO Server": {
+				"arm64": {Bin: &dlInfo{Download: "darwin-arm64/minio"}},
+			},
+		},
+	}
+	p := pivotByArch(d)
+	if p.SchemaVersion != downloadsSchemaVersion {
+		t.Errorf("SchemaVersion = %d, want %d", p.SchemaVersion, downloadsSchemaVersion)
+	}
+	testCases := []struct {
+		arch, platform, download string
+	}{
+		{"amd64", "Linux", "linux-amd64/minio"},
+		{"arm64", "Linux", "linux-arm64/minio"},
+		{"arm64", "macOS", "darwin-arm64/minio"},
+	}
+	for _, tc := range testCases {
+		dl, ok := p.Arches[tc.arch][tc.platform]
+		if !ok || dl.Bin == nil {
+			t.Errorf("no %s entry for %s", tc.platform, tc.arch)
+			continue
+		}
+		if dl.Bin.Download != tc.download {
+			t.Errorf("%s/%s download = %q, want %q", tc.arch, tc.platform, dl.Bin.Download, tc.download)
+		}
+	}
+	if n := len(p.Arches["amd64"]); n != 1 {
+		t.Errorf("amd64 has %d platforms, want 1", n)
+	}
+	if n := len(p.Arches); n != 2 {
+		t.Errorf("pivoted %d arches, want 2", n)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "downloads-minio.json")
+	if err := writeFileAtomic(path, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "new" {
+		t.Errorf("content = %q, want %q", buf, "new")
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("mode = %v, %v, want 0644", fi.Mode().Perm(), err)
+	}
+
+	testCases := []struct {
+		name string
+		path string
+	}{
+		// The temporary file cannot be created.
+		{"missing dir", filepath.Join(dir, "missing", "downloads-minio.json")},
+		// The temporary file cannot be renamed over a directory.
+		{"dir target", filepath.Join(dir, "target")},
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "target", "child"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range testCases {
+		if err := writeFileAtomic(tc.path, []byte("partial")); err == nil {
+			t.Errorf("%s: writeFileAtomic succeeded", tc.name)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "downloads-minio.json" && e.Name() != "target" {
+			t.Errorf("leftover file %s", e.Name())
+		}
+	}
+}
+
+// buildTestPackage packages a binary of binSize bytes with pkger and
+// returns the package path.
+func buildTestPackage(t *testing.T, pkger, arch string, binSize int) string {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, make([]byte, binSize), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := nfpm.Get(pkger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := nfpm.WithDefaults(&nfpm.Info{
+		Name:        "minio",
+		Arch:        arch,
+		Platform:    "linux",
+		Version:     "20240601000000.0.0",
+		Maintainer:  "MinIO Development <dev@minio.io>",
+		Description: "MinIO is a High Performance Object Storage.",
+		Overridables: nfpm.Overridables{
+			Contents: files.Contents{{Source: bin, Destination: "/usr/local/bin/minio"}},
+		},
+	})
+	path := filepath.Join(dir, pkg.ConventionalFileName(info))
+	if _, err = packageOnce(pkg, info, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInstalledSize(t *testing.T) {
+	const binSize = 256 << 10
+	testCases := []struct {
+		pkger string
+		path  string
+	}{
+		{"deb", buildTestPackage(t, "deb", "amd64", binSize)},
+		{"rpm", buildTestPackage(t, "rpm", "amd64", binSize)},
+	}
+	var built []builtPackage
+	for _, tc := range testCases {
+		size, err := pkgInstalledSize(tc.pkger, tc.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pkger, err)
+		}
+		// Installed sizes are rounded, but never below the payload.
+		if size < binSize || size > binSize+64<<10 {
+			t.Errorf("%s: installed size %d, want about %d", tc.pkger, size, binSize)
+		}
+		built = append(built, builtPackage{Packager: tc.pkger, Arch: "amd64", Path: tc.path, InstalledSize: size})
+	}
+
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {
+				"amd64": {
+					RPM: &dlInfo{Download: "linux-amd64/" + filepath.Base(testCases[1].path)},
+					Deb: &dlInfo{Download: "linux-amd64/" + filepath.Base(testCases[0].path)},
+					APK: &dlInfo{Download: "linux-amd64/minio-20240601000000.0.0.x86_64.apk"},
+				},
+			},
+		},
+	}
+	embedInstalledSizes(d, built)
+	dl := d.Linux["MinIO Server"]["amd64"]
+	if dl.Deb.InstalledSize != built[0].InstalledSize || dl.RPM.InstalledSize != built[1].InstalledSize {
+		t.Errorf("embedded sizes deb=%d rpm=%d, want %d and %d",
+			dl.Deb.InstalledSize, dl.RPM.InstalledSize, built[0].InstalledSize, built[1].InstalledSize)
+	}
+	if dl.APK.InstalledSize != 0 {
+		t.Errorf("unbuilt apk has installed size %d", dl.APK.InstalledSize)
+	}
+}
+
+func TestLoadArchMaps(t *testing.T) {
+	defer func(platforms map[string][]string) { appPlatformArches["minio"] = platforms }(appPlatformArches["minio"])
+	defer func(platforms map[string][]string) { appPlatformArches["minio-enterprise"] = platforms }(appPlatformArches["minio-enterprise"])
+	defer func(s []string) { *subscriptionNames = s }(*subscriptionNames)
+	defer delete(rpmArchMap, "riscv64")
+	*subscriptionNames = []string{"Enterprise"}
+
+	dir := t.TempDir()
+	testCases := []struct {
+		name      string
+		content   string
+		packagers []string
+		wantErr   bool
+	}{
+		{"unknown packager", "snap:\n  riscv64: riscv64\n", []string{"rpm"}, true},
+		{"unused packager", "deb:\n  riscv64: riscv64\n", []string{"rpm"}, true},
+		{"rpm riscv64", "rpm:\n  riscv64: riscv64\n", []string{"rpm", "deb"}, false},
+	}
+	for i, tc := range testCases {
+		path := filepath.Join(dir, fmt.Sprintf("arch-map-%d.yaml", i))
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadArchMaps(path, "minio", tc.packagers); (err != nil) != tc.wantErr {
+			t.Errorf("%s: loadArchMaps error = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+	if _, ok := debArchMap["riscv64"]; ok {
+		t.Errorf("rejected deb map was applied")
+	}
+	if rpmArchMap["riscv64"] != "riscv64" {
+		t.Fatalf("rpm riscv64 mapped to %q", rpmArchMap["riscv64"])
+	}
+
+	// The mapped arch is released alongside the listed ones.
+	arches := platformArches("minio", "linux")
+	if !slices.Contains(arches, "riscv64") || !slices.Contains(arches, "amd64") {
+		t.Errorf("linux arches = %v, want riscv64 added", arches)
+	}
+	if darwin := platformArches("minio", "darwin"); slices.Contains(darwin, "riscv64") {
+		t.Errorf("darwin arches = %v, riscv64 leaked", darwin)
+	}
+
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	dl := d.Linux["MinIO Server"]["riscv64"]
+	if dl.RPM == nil || !strings.HasSuffix(dl.RPM.Download, "linux-riscv64/minio-20240601000000.0.0-1.riscv64.rpm") {
+		t.Errorf("riscv64 rpm = %+v", dl.RPM)
+	}
+	if dl.Deb != nil {
+		t.Errorf("riscv64 has a deb entry %s without a deb arch", dl.Deb.Download)
+	}
+
+	// The enterprise downloads, alone and combined, drop the deb too.
+	path := filepath.Join(dir, "arch-map-2.yaml")
+	if err := loadArchMaps(path, "minio-enterprise", []string{"rpm"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, subscriptions := range map[string]map[string]downloadsJSON{
+		"enterprise": generateEnterpriseDownloadsJSON("20240601000000.0.0", "minio-enterprise").Subscriptions,
+		"combined":   generateCombinedDownloadsJSON("20240601000000.0.0", "minio-enterprise").Subscriptions,
+	} {
+		dl, ok := subscriptions["Enterprise"].Linux["AIStor Object Store"]["riscv64"]
+		if !ok {
+			t.Errorf("%s: no riscv64 entry", name)
+			continue
+		}
+		if dl.RPM == nil || !strings.HasSuffix(dl.RPM.Download, "linux-riscv64/minio-20240601000000.0.0-1.riscv64.rpm") {
+			t.Errorf("%s: riscv64 rpm = %+v", name, dl.RPM)
+		}
+		if dl.Deb != nil {
+			t.Errorf("%s: riscv64 has a deb entry %s without a deb arch", name, dl.Deb.Download)
+		}
+	}
+}
+
+func TestPkgExt(t *testing.T) {
+	testCases := []struct {
+		path, want string
+	}{
+		{"minio-20240601000000.0.0-1.x86_64.rpm", ".rpm"},
+		{"minio_20240601000000.0.0_amd64.deb", ".deb"},
+		{"minio_20240601000000.0.0_x86_64.apk", ".apk"},
+		{"minio-20240601000000.0.0-1-x86_64.pkg.tar.zst", ".pkg.tar.zst"},
+		{"minio.tar.zst", ".zst"},
+	}
+	for _, tc := range testCases {
+		if got := pkgExt(tc.path); got != tc.want {
+			t.Errorf("pkgExt(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		path := buildTestPackage(t, "archlinux", archLinuxArchMap[arch], 1<<10)
+		base := filepath.Base(path)
+		if !strings.HasSuffix(base, "-"+archLinuxArchMap[arch]+".pkg.tar.zst") {
+			t.Errorf("%s: archlinux package named %s", arch, base)
+		}
+		if got := pkgExt(path); got != ".pkg.tar.zst" {
+			t.Errorf("%s: pkgExt(%q) = %q", arch, base, got)
+		}
+	}
+}
+
+func TestDropUnpackagedArches(t *testing.T) {
+	entry := func() downloadJSON {
+		return downloadJSON{Bin: &dlInfo{}, RPM: &dlInfo{}, Deb: &dlInfo{}}
+	}
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {
+				"amd64":   entry(),
+				"ppc64le": entry(),
+				"riscv64": entry(),
+			},
+		},
+	}
+	dropUnpackagedArches(d)
+
+	testCases := []struct {
+		arch     string
+		rpm, deb bool
+	}{
+		{"amd64", true, true},
+		{"ppc64le", true, true},
+		{"riscv64", false, false},
+	}
+	for _, tc := range testCases {
+		dl := d.Linux["MinIO Server"][tc.arch]
+		if dl.Bin == nil {
+			t.Errorf("%s: binary entry dropped", tc.arch)
+		}
+		if (dl.RPM != nil) != tc.rpm || (dl.Deb != nil) != tc.deb {
+			t.Errorf("%s: rpm %v deb %v, want rpm %v deb %v", tc.arch, dl.RPM != nil, dl.Deb != nil, tc.rpm, tc.deb)
+		}
+	}
+
+	// No generator links to packages of an arch without arch names.
+	defer func(s []string) { *subscriptionNames = s }(*subscriptionNames)
+	*subscriptionNames = []string{"Enterprise"}
+	for _, app := range []string{"minio", "minio-enterprise", "mc-enterprise", "kubectl-minio"} {
+		defer func(app string, platforms map[string][]string) { appPlatformArches[app] = platforms }(app, appPlatformArches[app])
+		addPlatformArch(app, "linux", "riscv64")
+	}
+	generators := map[string]func() []downloadsJSON{
+		"community": func() []downloadsJSON { return allDownloads(generateDownloadsJSON("20240601000000.0.0", "minio")) },
+		"kubectl":   func() []downloadsJSON { return allDownloads(generateKubectlMinioDownloadsJSON("20240601000000.0.0")) },
+		"minio-enterprise": func() []downloadsJSON {
+			return allDownloads(generateEnterpriseDownloadsJSON("20240601000000.0.0", "minio-enterprise"))
+		},
+		"mc-enterprise": func() []downloadsJSON {
+			return allDownloads(generateEnterpriseDownloadsJSON("20240601000000.0.0", "mc-enterprise"))
+		},
+		"combined": func() []downloadsJSON {
+			return allDownloads(generateCombinedDownloadsJSON("20240601000000.0.0", "minio-enterprise"))
+		},
+	}
+	for name, generate := range generators {
+		entries := 0
+		for _, dj := range generate() {
+			for product, arches := range dj.Linux {
+				dl, ok := arches["riscv64"]
+				if !ok {
+					continue
+				}
+				entries++
+				if dl.RPM != nil || dl.Deb != nil {
+					t.Errorf("%s: %s riscv64 lists rpm %v deb %v", name, product, dl.RPM != nil, dl.Deb != nil)
+				}
+			}
+		}
+		if entries == 0 {
+			t.Errorf("%s: no riscv64 entries", name)
+		}
+	}
+
+	// Every package listed for ppc64le is named after the packager arch.
+	for _, app := range []string{"minio", "mc"} {
+		d := generateDownloadsJSON("20240601000000.0.0", app)
+		for _, arches := range d.Linux {
+			dl, ok := arches["ppc64le"]
+			if !ok {
+				t.Errorf("%s: no ppc64le entry", app)
+				continue
+			}
+			if dl.RPM == nil || !strings.HasSuffix(dl.RPM.Download, ".ppc64le.rpm") {
+				t.Errorf("%s: ppc64le rpm = %+v", app, dl.RPM)
+			}
+			if dl.Deb == nil || !strings.HasSuffix(dl.Deb.Download, "_ppc64el.deb") {
+				t.Errorf("%s: ppc64le deb = %+v", app, dl.Deb)
+			}
+		}
+	}
+}
+
+func TestEmbedSizes(t *testing.T) {
+	defer func(o, r string) { *osName, *releaseDir = o, r }(*osName, *releaseDir)
+	*osName, *releaseDir = "linux", t.TempDir()
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	bin := platformBinary("minio", release, "linux", "amd64")
+	if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bin, make([]byte, 3000), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rpm := filepath.Join(filepath.Dir(bin), "minio-20240601000000.0.0-1.x86_64.rpm")
+	built := []builtPackage{{Packager: "rpm", Arch: "amd64", Path: rpm, Size: 2000}}
+
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	embedSizes(d, "minio", release, built)
+
+	testCases := []struct {
+		arch string
+		info func(downloadJSON) *dlInfo
+		want int64
+	}{
+		{"amd64", func(dl downloadJSON) *dlInfo { return dl.Bin }, 3000},
+		{"amd64", func(dl downloadJSON) *dlInfo { return dl.RPM }, 2000},
+		// Packages not built in this run keep no size.
+		{"amd64", func(dl downloadJSON) *dlInfo { return dl.Deb }, 0},
+		{"arm64", func(dl downloadJSON) *dlInfo { return dl.Bin }, 0},
+		{"arm64", func(dl downloadJSON) *dlInfo { return dl.RPM }, 0},
+	}
+	for i, tc := range testCases {
+		info := tc.info(d.Linux["MinIO Server"][tc.arch])
+		if info == nil {
+			t.Errorf("case %d: no %s entry", i, tc.arch)
+			continue
+		}
+		if info.Size != tc.want {
+			t.Errorf("case %d: %s size = %d, want %d", i, info.Download, info.Size, tc.want)
+		}
+	}
+
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(d.Linux["MinIO Server"]["amd64"].RPM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"size":2000`) {
+		t.Errorf("size missing from %s", buf)
+	}
+}
+
+func TestSelectPackagers(t *testing.T) {
+	appCapsTable["pkger-test"] = appCapabilities{Packages: true, Packagers: []string{"deb", "archlinux"}}
+	defer delete(appCapsTable, "pkger-test")
+
+	testCases := []struct {
+		app      string
+		packager string
+		want     string
+		wantErr  bool
+	}{
+		{"minio", "deb,rpm,apk", "deb,rpm,apk", false},
+		{"minio", "ipk", "ipk", false},
+		// Manifests list any combination of packagers.
+		{"minio", "archlinux,deb,deb", "archlinux,deb", false},
+		{"minio", "snap", "", true},
+		{"minio", "", "", true},
+		{"pkger-test", "archlinux,deb", "archlinux,deb", false},
+		{"pkger-test", "deb,rpm,apk", "", true},
+	}
+	for _, tc := range testCases {
+		got, err := selectPackagers(tc.app, tc.packager)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("selectPackagers(%s, %q) error = %v, want error %v", tc.app, tc.packager, err, tc.wantErr)
+			continue
+		}
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("selectPackagers(%s, %q) = %q, want %q", tc.app, tc.packager, got, tc.want)
+		}
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*releaseDir = filepath.Join(dir, "out")
+
+	binaries := map[string]string{
+		"amd64": filepath.Join(dir, "build", "mc-x86"),
+		"arm64": filepath.Join(dir, "build", "mc-aarch64"),
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "build"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, bin := range binaries {
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "release.yaml")
+	content := fmt.Sprintf("app: mc\nrelease: RELEASE.2024-06-01T00-00-00Z\npackagers: [deb, rpm]\nbinaries:\n  amd64: %s\n  arm64: %s\n",
+		binaries["amd64"], binaries["arm64"])
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest = &m
+	defer func() { manifest = nil }()
+
+	built, err := doPackage(m.App, m.Release, strings.Join(m.Packagers, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		packager, arch, name string
+	}{
+		{"deb", "amd64", "linux-amd64/mcli_20240601000000.0.0_amd64.deb"},
+		{"rpm", "amd64", "linux-amd64/mcli-20240601000000.0.0-1.x86_64.rpm"},
+		{"deb", "arm64", "linux-arm64/mcli_20240601000000.0.0_arm64.deb"},
+		{"rpm", "arm64", "linux-arm64/mcli-20240601000000.0.0-1.aarch64.rpm"},
+	}
+	if len(built) != len(testCases) {
+		t.Fatalf("built %d packages, want %d", len(built), len(testCases))
+	}
+
+	d := generateDownloadsJSON("20240601000000.0.0", m.App)
+	arches := d.Linux["MinIO Client"]
+	if len(arches) != len(binaries) {
+		t.Errorf("downloads list %d linux arches, want %d", len(arches), len(binaries))
+	}
+	if n := len(d.MacOS["MinIO Client"]) + len(d.Windows["MinIO Client"]); n != 0 {
+		t.Errorf("downloads list %d arches the manifest does not build", n)
+	}
+	for _, tc := range testCases {
+		want := filepath.Join(dir, "out", tc.name)
+		found := false
+		for _, b := range built {
+			found = found || (b.Packager == tc.packager && b.Arch == tc.arch && b.Path == want)
+		}
+		if !found {
+			t.Errorf("%s %s package %s not built", tc.arch, tc.packager, tc.name)
+		}
+		if _, err := os.Stat(want); err != nil {
+			t.Error(err)
+		}
+
+		dl := arches[tc.arch]
+		info := dl.Deb
+		if tc.packager == "rpm" {
+			info = dl.RPM
+		}
+		if info == nil || !strings.HasSuffix(info.Download, "/"+tc.name) {
+			t.Errorf("%s %s download = %+v, want %s", tc.arch, tc.packager, info, tc.name)
+		}
+		if dl.APK != nil {
+			t.Errorf("%s lists an apk the manifest does not build", tc.arch)
+		}
+	}
+}
+
+func TestS390x(t *testing.T) {
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	dl, ok := d.Linux["MinIO Server"]["s390x"]
+	if !ok {
+		t.Fatal("no s390x entry for minio")
+	}
+	testCases := []struct {
+		pkger string
+		info  *dlInfo
+		name  string
+	}{
+		{"rpm", dl.RPM, "minio-20240601000000.0.0-1.s390x.rpm"},
+		{"deb", dl.Deb, "minio_20240601000000.0.0_s390x.deb"},
+		{"apk", dl.APK, "minio_20240601000000.0.0_s390x.apk"},
+	}
+	for _, tc := range testCases {
+		if tc.info == nil {
+			t.Errorf("no s390x %s entry", tc.pkger)
+			continue
+		}
+		if want := "linux-s390x/" + tc.name; !strings.HasSuffix(tc.info.Download, want) {
+			t.Errorf("s390x %s download = %s, want %s", tc.pkger, tc.info.Download, want)
+		}
+		// The JSON links to the file nfpm names the package.
+		if got := filepath.Base(buildTestPackage(t, tc.pkger, "s390x", 1<<10)); got != tc.name {
+			t.Errorf("s390x %s package named %s, want %s", tc.pkger, got, tc.name)
+		}
+	}
+
+	for _, app := range []string{"minio-enterprise", "mc-enterprise", "sidekick", "warp"} {
+		for _, arch := range platformArches(app, "linux") {
+			if arch == "s390x" {
+				t.Errorf("%s is released for s390x", app)
+			}
+		}
+	}
+}
+
+func TestNameMap(t *testing.T) {
+	defer func(m map[string]string) { *nameMap = m }(*nameMap)
+	*nameMap = map[string]string{"deb": "mc-cli", "rpm": "minio-client"}
+
+	d := generateDownloadsJSON("20240601000000.0.0", "mc")
+	dl := d.Linux["MinIO Client"]["amd64"]
+	testCases := []struct {
+		pkger string
+		info  *dlInfo
+		want  string
+	}{
+		{"deb", dl.Deb, "mc-cli_20240601000000.0.0_amd64.deb"},
+		{"rpm", dl.RPM, "minio-client-20240601000000.0.0-1.x86_64.rpm"},
+		// Packagers without an override keep the default name.
+		{"apk", dl.APK, "mcli_20240601000000.0.0_x86_64.apk"},
+	}
+	for _, tc := range testCases {
+		pkg, err := nfpm.Get(tc.pkger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := pkg.ConventionalFileName(nfpm.WithDefaults(&nfpm.Info{
+			Name:     packagerName("mc", tc.pkger),
+			Arch:     "amd64",
+			Platform: "linux",
+			Version:  "20240601000000.0.0",
+		}))
+		if got != tc.want {
+			t.Errorf("%s package named %s, want %s", tc.pkger, got, tc.want)
+		}
+		if tc.info == nil {
+			t.Errorf("no %s entry", tc.pkger)
+			continue
+		}
+		for _, s := range []string{tc.info.Download, tc.info.Checksum, tc.info.Text} {
+			if !strings.Contains(s, "/"+tc.want) {
+				t.Errorf("%s entry %q does not link to %s", tc.pkger, s, tc.want)
+			}
+		}
+	}
+}
+
+// flakyPackager fails its first failures calls to Package with err,
+// after writing a partial package.
+type flakyPackager struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (p *flakyPackager) Package(_ *nfpm.Info, w io.Writer) error {
+	p.calls++
+	if p.calls <= p.failures {
+		_, _ = w.Write([]byte("partial"))
+		return p.err
+	}
+	_, err := w.Write([]byte("package"))
+	return err
+}
+
+func (p *flakyPackager) ConventionalFileName(_ *nfpm.Info) string {
+	return "minio.deb"
+}
+
+func TestPackageWithRetries(t *testing.T) {
+	defer func(n int, d time.Duration) { *pkgRetries, retryDelay = n, d }(*pkgRetries, retryDelay)
+	*pkgRetries, retryDelay = 2, 0
+
+	busy := &os.PathError{Op: "write", Path: "minio.deb", Err: syscall.EBUSY}
+	testCases := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", 0, nil, 1, false},
+		{"transient once", 1, busy, 2, false},
+		{"transient twice", 2, busy, 3, false},
+		{"retries exhausted", 3, busy, 3, true},
+		{"not transient", 1, errors.New("invalid package"), 1, true},
+	}
+	for _, tc := range testCases {
+		tgtPath := filepath.Join(t.TempDir(), "minio.deb")
+		pkg := &flakyPackager{failures: tc.failures, err: tc.err}
+		sum, err := packageWithRetries(pkg, &nfpm.Info{}, tgtPath)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+		if pkg.calls != tc.wantCalls {
+			t.Errorf("%s: packaged %d times, want %d", tc.name, pkg.calls, tc.wantCalls)
+		}
+		buf, statErr := os.ReadFile(tgtPath)
+		if tc.wantErr {
+			if !os.IsNotExist(statErr) {
+				t.Errorf("%s: failed package left at %s", tc.name, tgtPath)
+			}
+			continue
+		}
+		if string(buf) != "package" {
+			t.Errorf("%s: package content %q, want %q", tc.name, buf, "package")
+		}
+		if want := sha256.Sum256(buf); !bytes.Equal(sum, want[:]) {
+			t.Errorf("%s: sha256 %x, want %x", tc.name, sum, want)
+		}
+	}
+}
+
+// packageForTest packages a minio amd64 binary with pkger into a
+// temporary release directory and returns the package path, the caller
+// sets the flags under test.
+func packageForTest(t *testing.T, pkger string) string {
+	t.Helper()
+	built := buildForTest(t, pkger)
+	if len(built) != 1 {
+		t.Fatalf("built %d %s packages, want 1", len(built), pkger)
+	}
+	return built[0].Path
+}
+
+// buildForTest packages a minio amd64 binary with the comma separated
+// packagers into a temporary release directory and returns the built
+// packages.
+func buildForTest(t *testing.T, packager string) []builtPackage {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return packageBinary(t, packager, bin, filepath.Join(dir, "out"))
+}
+
+// packageBinary packages bin as the minio amd64 binary with the comma
+// separated packagers into the release directory out, along with the
+// minio.service next to bin.
+func packageBinary(t *testing.T, packager, bin, out string) []builtPackage {
+	t.Helper()
+	built, err := tryPackageBinary(t, packager, bin, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return built
+}
+
+// tryPackageBinary is packageBinary returning the doPackage error.
+func tryPackageBinary(t *testing.T, packager, bin, out string) ([]builtPackage, error) {
+	t.Helper()
+	unit := filepath.Join(filepath.Dir(bin), "minio.service")
+	if _, err := os.Stat(unit); os.IsNotExist(err) {
+		if err = os.WriteFile(unit, []byte("[Unit]\nDescription=MinIO\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(m *releaseManifest, d, s string) { manifest, *releaseDir, *serviceFile = m, d, s }(manifest, *releaseDir, *serviceFile)
+	manifest = &releaseManifest{App: "minio", Binaries: map[string]string{"amd64": bin}}
+	*releaseDir, *serviceFile = out, unit
+
+	return doPackage("minio", "RELEASE.2024-06-01T00-00-00Z", packager)
+}
+
+// debFiles returns the files in the deb at pkgPath, the control files
+// keyed by `control/<name>` and the data files by their path.
+func debFiles(t *testing.T, pkgPath string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	walkDeb(t, pkgPath, func(name string, th *tar.Header, body []byte) {
+		if !th.FileInfo().IsDir() {
+			contents[name] = string(body)
+		}
+	})
+	return contents
+}
+
+// debHeaders returns the tar headers of the entries in the deb at
+// pkgPath, keyed like debFiles, directories included.
+func debHeaders(t *testing.T, pkgPath string) map[string]*tar.Header {
+	t.Helper()
+	headers := make(map[string]*tar.Header)
+	walkDeb(t, pkgPath, func(name string, th *tar.Header, _ []byte) {
+		headers[name] = th
+	})
+	return headers
+}
+
+// walkDeb calls fn for every entry of the control and data members of
+// the deb at pkgPath, named like debFiles.
+func walkDeb(t *testing.T, pkgPath string, fn func(name string, th *tar.Header, body []byte)) {
+	t.Helper()
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := ar.NewReader(f)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		prefix := "control/"
+		switch {
+		case strings.HasPrefix(name, "control.tar"):
+		case strings.HasPrefix(name, "data.tar"):
+			prefix = "/"
+		default:
+			continue
+		}
+		tr, err := debTarReader(name, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			th, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fn(prefix+strings.TrimPrefix(path.Clean(th.Name), "/"), th, buf)
+		}
+	}
+}
+
+func TestSystemdDropins(t *testing.T) {
+	defer func(d []string) { *systemdDropins = d }(*systemdDropins)
+
+	dir := t.TempDir()
+	testCases := []struct {
+		name, content string
+	}{
+		{"override.conf", "[Service]\nEnvironment=MINIO_OPTS=--quiet\n"},
+		{"limits.conf", "[Service]\nLimitNOFILE=1048576\n"},
+	}
+	*systemdDropins = nil
+	for _, tc := range testCases {
+		src := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(src, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		*systemdDropins = append(*systemdDropins, src)
+	}
+
+	contents := debFiles(t, packageForTest(t, "deb"))
+	for _, tc := range testCases {
+		dst := "/etc/systemd/system/minio.service.d/" + tc.name
+		if got, ok := contents[dst]; !ok || got != tc.content {
+			t.Errorf("%s = %q, %v, want %q", dst, got, ok, tc.content)
+		}
+		// Drop-ins are configuration kept on upgrade.
+		if !slices.Contains(strings.Fields(contents["control/conffiles"]), dst) {
+			t.Errorf("%s is not a conffile:\n%s", dst, contents["control/conffiles"])
+		}
+	}
+}
+
+// apkFiles returns the files in the apk at pkgPath keyed by their name,
+// the signature, control and data segments are read as one stream.
+func apkFiles(t *testing.T, pkgPath string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	walkAPK(t, pkgPath, func(th *tar.Header, body []byte) {
+		if !th.FileInfo().IsDir() {
+			contents[path.Clean(th.Name)] = string(body)
+		}
+	})
+	return contents
+}
+
+// walkAPK calls fn for every entry of the apk at pkgPath.
+func walkAPK(t *testing.T, pkgPath string, fn func(th *tar.Header, body []byte)) {
+	t.Helper()
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(zr)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn(th, buf)
+	}
+}
+
+func TestAPKUpgradeScripts(t *testing.T) {
+	defer func(d string) { *scriptsDir = d }(*scriptsDir)
+	*scriptsDir = t.TempDir()
+
+	testCases := []struct {
+		script, control string
+	}{
+		{"preupgrade.sh", ".pre-upgrade"},
+		{"postupgrade.sh", ".post-upgrade"},
+	}
+	for _, tc := range testCases {
+		content := "#!/bin/sh\necho " + tc.script + "\n"
+		if err := os.WriteFile(filepath.Join(*scriptsDir, tc.script), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apk := apkFiles(t, packageForTest(t, "apk"))
+	deb := debFiles(t, packageForTest(t, "deb"))
+	for _, tc := range testCases {
+		want := "#!/bin/sh\necho " + tc.script + "\n"
+		if got := apk[tc.control]; got != want {
+			t.Errorf("apk %s = %q, want %q", tc.control, got, want)
+		}
+		// Debs have no upgrade scripts, none of their scripts runs it.
+		for name, content := range deb {
+			if strings.HasPrefix(name, "control/") && strings.Contains(content, "echo "+tc.script) {
+				t.Errorf("deb %s embeds %s", name, tc.script)
+			}
+		}
+	}
+}
+
+func TestDebconf(t *testing.T) {
+	defer func(tmpl, config string) { *debconfTemplates, *debconfConfig = tmpl, config }(*debconfTemplates, *debconfConfig)
+
+	dir := t.TempDir()
+	testCases := []struct {
+		flag    *string
+		name    string
+		content string
+	}{
+		{debconfTemplates, "templates", "Template: minio/volumes\nType: string\nDescription: MinIO volumes\n"},
+		{debconfConfig, "config", "#!/bin/sh\nset -e\n. /usr/share/debconf/confmodule\ndb_input medium minio/volumes || true\ndb_go\n"},
+	}
+	for _, tc := range testCases {
+		*tc.flag = filepath.Join(dir, tc.name)
+		if err := os.WriteFile(*tc.flag, []byte(tc.content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents := debFiles(t, packageForTest(t, "deb"))
+	for _, tc := range testCases {
+		if got := contents["control/"+tc.name]; got != tc.content {
+			t.Errorf("control %s = %q, want %q", tc.name, got, tc.content)
+		}
+	}
+}
+
+// rpmHeaderStrings returns the string values of tag in the main header
+// of the rpm at pkgPath.
+func rpmHeaderStrings(t *testing.T, pkgPath string, tag uint32) []string {
+	t.Helper()
+	buf, err := os.ReadFile(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The signature header is padded to 8 bytes, the main header is not.
+	off := rpmLeadSize
+	for i := 0; i < 2; i++ {
+		if len(buf) < off+16 || string(buf[off:off+4]) != rpmHeaderMagic {
+			t.Fatalf("invalid rpm header in %s", pkgPath)
+		}
+		nindex := int(binary.BigEndian.Uint32(buf[off+8:]))
+		hsize := int(binary.BigEndian.Uint32(buf[off+12:]))
+		index := buf[off+16 : off+16+nindex*rpmIndexEntrySize]
+		store := buf[off+16+len(index) : off+16+len(index)+hsize]
+		if i == 0 {
+			off += 16 + len(index) + (hsize+7)/8*8
+			continue
+		}
+		for e := 0; e < nindex; e++ {
+			entry := index[e*rpmIndexEntrySize:]
+			if binary.BigEndian.Uint32(entry[0:4]) != tag {
+				continue
+			}
+			// STRING, STRING_ARRAY and I18NSTRING are NUL terminated.
+			data := store[binary.BigEndian.Uint32(entry[8:12]):]
+			var values []string
+			for n := binary.BigEndian.Uint32(entry[12:16]); n > 0; n-- {
+				end := bytes.IndexByte(data, 0)
+				values = append(values, string(data[:end]))
+				data = data[end+1:]
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+func TestRPMTransScripts(t *testing.T) {
+	defer func(d string) { *scriptsDir = d }(*scriptsDir)
+	*scriptsDir = t.TempDir()
+
+	testCases := []struct {
+		script string
+		tag    uint32
+	}{
+		{"pretrans.sh", 1151},
+		{"posttrans.sh", 1152},
+	}
+	for _, tc := range testCases {
+		content := "#!/bin/sh\necho " + tc.script + "\n"
+		if err := os.WriteFile(filepath.Join(*scriptsDir, tc.script), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rpm := packageForTest(t, "rpm")
+	for _, tc := range testCases {
+		got := rpmHeaderStrings(t, rpm, tc.tag)
+		if len(got) != 1 || !strings.Contains(got[0], "echo "+tc.script) {
+			t.Errorf("rpm tag %d = %q, want %s", tc.tag, got, tc.script)
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	defer func(m string) { *metaFile = m }(*metaFile)
+
+	meta := pkgMeta{
+		Description: "Object storage: \"fast\" # and simple",
+		License:     "AGPL-3.0-or-later OR Commercial",
+		Maintainer:  "MinIO, Inc. <dev@min.io>",
+		Vendor:      "MinIO: {Inc}",
+		Homepage:    "https://min.io/?a=1&b=#2",
+		Section:     "net",
+		Summary:     "S3: compatible 'object' storage",
+	}
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(map[string]string{
+		"description": meta.Description,
+		"license":     meta.License,
+		"maintainer":  meta.Maintainer,
+		"vendor":      meta.Vendor,
+		"homepage":    meta.Homepage,
+		"section":     meta.Section,
+		"summary":     meta.Summary,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	*metaFile = filepath.Join(t.TempDir(), "meta.yaml")
+	if err = os.WriteFile(*metaFile, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	control, err := parseControl(strings.NewReader(debFiles(t, packageForTest(t, "deb"))["control/control"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpm := packageForTest(t, "rpm")
+	testCases := []struct {
+		field string
+		got   []string
+		want  string
+	}{
+		{"deb Description", []string{control["Description"]}, meta.Description},
+		{"deb Maintainer", []string{control["Maintainer"]}, meta.Maintainer},
+		{"deb Homepage", []string{control["Homepage"]}, meta.Homepage},
+		{"deb Section", []string{control["Section"]}, meta.Section},
+		{"rpm Summary", rpmHeaderStrings(t, rpm, 1004), meta.Summary},
+		{"rpm Vendor", rpmHeaderStrings(t, rpm, 1011), meta.Vendor},
+		{"rpm License", rpmHeaderStrings(t, rpm, 1014), meta.License},
+		{"rpm Packager", rpmHeaderStrings(t, rpm, 1015), meta.Maintainer},
+		{"rpm URL", rpmHeaderStrings(t, rpm, 1020), meta.Homepage},
+	}
+	for _, tc := range testCases {
+		if len(tc.got) != 1 || tc.got[0] != tc.want {
+			t.Errorf("%s = %q, want %q", tc.field, tc.got, tc.want)
+		}
+	}
+
+	// Values spanning lines would break out of their YAML scalar.
+	for _, field := range []string{"license", "maintainer", "vendor", "homepage", "section", "summary"} {
+		path := filepath.Join(t.TempDir(), "meta.yaml")
+		content := fmt.Sprintf("%s: \"x\\nscripts: {postinstall: /tmp/x}\"\n", field)
+		if err = os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = loadMeta(path); err == nil {
+			t.Errorf("loadMeta accepted a multi-line %s", field)
+		}
+	}
+}
+
+func TestObsoletes(t *testing.T) {
+	defer func(o []string) { *obsoletes = o }(*obsoletes)
+
+	testCases := []struct {
+		obsoletes []string
+	}{
+		{[]string{"mc"}},
+		{[]string{"mc", "minio-client"}},
+	}
+	for _, tc := range testCases {
+		*obsoletes = tc.obsoletes
+		want := strings.Join(tc.obsoletes, ", ")
+
+		control, err := parseControl(strings.NewReader(debFiles(t, packageForTest(t, "deb"))["control/control"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{"Replaces", "Breaks"} {
+			if control[field] != want {
+				t.Errorf("deb %s = %q, want %q", field, control[field], want)
+			}
+		}
+		// 1090 is RPMTAG_OBSOLETENAME.
+		if got := rpmHeaderStrings(t, packageForTest(t, "rpm"), 1090); !slices.Equal(got, tc.obsoletes) {
+			t.Errorf("rpm Obsoletes = %q, want %q", got, tc.obsoletes)
+		}
+	}
+}
+
+func TestReconcileDownloads(t *testing.T) {
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*releaseDir = t.TempDir()
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	testCases := []struct {
+		arch, name string
+		// content is the local package, none when empty.
+		content string
+		// sha256 is embedded in the JSON, checksum is the local
+		// checksum file.
+		sha256, checksum string
+		want             string
+	}{
+		{"amd64", "minio-1.x86_64.rpm", "rpm", sum("rpm"), "", ""},
+		{"amd64", "minio_1_amd64.deb", "deb", "", sum("deb"), ""},
+		{"amd64", "minio_1_x86_64.apk", "", "", "", "missing: "},
+		{"arm64", "minio-1.aarch64.rpm", "rpm", sum("other"), "", "checksum mismatch: "},
+		{"arm64", "minio_1_arm64.deb", "deb", "", sum("other"), "checksum mismatch: "},
+	}
+	d := downloadsJSON{Linux: map[string]map[string]downloadJSON{"MinIO Server": {}}}
+	for _, tc := range testCases {
+		dir := filepath.Join(*releaseDir, "linux-"+tc.arch)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if tc.content != "" {
+			if err := os.WriteFile(filepath.Join(dir, tc.name), []byte(tc.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.checksum != "" {
+			if err := os.WriteFile(filepath.Join(dir, tc.name)+*checksumSuffix, []byte(tc.checksum+"  "+tc.name), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		info := &dlInfo{Download: "https://dl.min.io/server/minio/release/linux-" + tc.arch + "/" + tc.name, SHA256: tc.sha256}
+		dl := d.Linux["MinIO Server"][tc.arch]
+		switch filepath.Ext(tc.name) {
+		case ".rpm":
+			dl.RPM = info
+		case ".deb":
+			dl.Deb = info
+		case ".apk":
+			dl.APK = info
+		}
+		d.Linux["MinIO Server"][tc.arch] = dl
+	}
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(*releaseDir, "downloads-minio.json")
+	if err = os.WriteFile(jsonPath, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := reconcileDownloads(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := 0
+	for _, tc := range testCases {
+		if tc.want == "" {
+			continue
+		}
+		reported++
+		local := filepath.Join(*releaseDir, "linux-"+tc.arch, tc.name)
+		found := false
+		for _, p := range problems {
+			found = found || (strings.HasPrefix(p, tc.want) && strings.Contains(p, local+" "))
+		}
+		if !found {
+			t.Errorf("%s: no %q problem in %q", tc.name, tc.want, problems)
+		}
+	}
+	if len(problems) != reported {
+		t.Errorf("reported %d problems, want %d: %q", len(problems), reported, problems)
+	}
+}
+
+func TestDebPostRemove(t *testing.T) {
+	dir := t.TempDir()
+	postrm := filepath.Join(dir, "postrm")
+	if err := writeDebPostRemove(postrm, "minio"); err != nil {
+		t.Fatal(err)
+	}
+
+	// rm logs its arguments instead of deleting anything.
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "log")
+	script := fmt.Sprintf("#!/bin/sh\necho rm \"$@\" >>%s\n", log)
+	if err := os.WriteFile(filepath.Join(bin, "rm"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not installed")
+	}
+
+	testCases := []struct {
+		action string
+		want   []string
+	}{
+		{"remove", nil},
+		{"upgrade", nil},
+		{"purge", []string{
+			"rm -f /etc/default/minio",
+			"rm -rf /etc/minio",
+		}},
+	}
+	for _, tc := range testCases {
+		os.Remove(log)
+		cmd := exec.Command(sh, postrm, tc.action)
+		cmd.Env = []string{"PATH=" + bin}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("postrm %s: %v: %s", tc.action, err, out)
+		}
+		buf, _ := os.ReadFile(log)
+		var got []string
+		if s := strings.TrimSpace(string(buf)); s != "" {
+			got = strings.Split(s, "\n")
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("postrm %s ran %q, want %q", tc.action, got, tc.want)
+		}
+	}
+
+	// The postrm is only generated when the package ships configuration.
+	defer func(d string) { *defaultsDir = d }(*defaultsDir)
+	for _, shipsConfig := range []bool{false, true} {
+		*defaultsDir = ""
+		if shipsConfig {
+			*defaultsDir = t.TempDir()
+			if err := os.WriteFile(filepath.Join(*defaultsDir, "config.env"), []byte("MINIO_VOLUMES=/mnt/data\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := debFiles(t, packageForTest(t, "deb"))["control/postrm"]
+		if hasPurge := strings.Contains(got, "rm -f '/etc/default/minio'"); hasPurge != shipsConfig {
+			t.Errorf("ships config %v, postrm purges it %v:\n%s", shipsConfig, hasPurge, got)
+		}
+	}
+
+	// A postremove.sh of the scripts dir replaces the purge, with a
+	// warning.
+	defer func(d string) { *scriptsDir = d }(*scriptsDir)
+	*scriptsDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(*scriptsDir, "postremove.sh"), []byte("#!/bin/sh\necho custom\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	stderr := captureStderr(t, func() {
+		got = debFiles(t, packageForTest(t, "deb"))["control/postrm"]
+	})
+	if strings.Contains(got, "rm -f") || !strings.Contains(got, "echo custom") {
+		t.Errorf("postrm is not the postremove.sh of the scripts dir:\n%s", got)
+	}
+	if !strings.Contains(stderr, "are not deleted on purge") {
+		t.Errorf("no warning the purge is replaced: %q", stderr)
+	}
+}
+
+func TestDebTriggers(t *testing.T) {
+	testCases := []struct {
+		flag    string
+		want    string
+		wantErr bool
+	}{
+		{"ldconfig", "activate-noawait ldconfig", false},
+		{"interest:/usr/share/applications", "interest /usr/share/applications", false},
+		{"activate-await:update-desktop-database", "activate-await update-desktop-database", false},
+		{"interest-noawait:minio-plugins", "interest-noawait minio-plugins", false},
+		{"await:ldconfig", "", true},
+		{"interest:", "", true},
+	}
+	var flags []string
+	for _, tc := range testCases {
+		_, err := parseDebTriggers([]string{tc.flag})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseDebTriggers(%q) error = %v, want error %v", tc.flag, err, tc.wantErr)
+		}
+		if !tc.wantErr {
+			flags = append(flags, tc.flag)
+		}
+	}
+
+	defer func(f []string) { *debTriggerFlags = f }(*debTriggerFlags)
+	*debTriggerFlags = flags
+	triggers := debFiles(t, packageForTest(t, "deb"))["control/triggers"]
+	lines := strings.Split(strings.TrimSpace(triggers), "\n")
+	for _, tc := range testCases {
+		if !tc.wantErr && !slices.Contains(lines, tc.want) {
+			t.Errorf("trigger %q not declared in:\n%s", tc.want, triggers)
+		}
+	}
+}
+
+func TestDiffDownloads(t *testing.T) {
+	defer func(r bool) { *relativeURLs = r }(*relativeURLs)
+
+	const base = "https://dl.min.io/server/minio/release/linux-amd64/"
+	testCases := []struct {
+		name     string
+		relative bool
+		old, new string
+		want     []string
+	}{
+		{
+			"unchanged", false,
+			`{"Linux":{"amd64":{"Binary":{"download":"` + base + `minio"}}}}`,
+			`{"Linux":{"amd64":{"Binary":{"download":"` + base + `minio"}}}}`,
+			nil,
+		},
+		{
+			"changed", false,
+			`{"Linux":{"amd64":{"RPM":{"download":"` + base + `minio-1.x86_64.rpm"}}}}`,
+			`{"Linux":{"amd64":{"RPM":{"download":"` + base + `minio-2.x86_64.rpm"}}}}`,
+			[]string{"~ /Linux/amd64/RPM/download: " + base + "minio-1.x86_64.rpm -> " + base + "minio-2.x86_64.rpm"},
+		},
+		{
+			"added and removed", false,
+			`{"Linux":{"amd64":{"DEB":{"download":"` + base + `minio_1_amd64.deb"}}}}`,
+			`{"Linux":{"amd64":{"APK":{"download":"` + base + `minio_1_x86_64.apk"}}}}`,
+			[]string{
+				"+ /Linux/amd64/APK/download: " + base + "minio_1_x86_64.apk",
+				"- /Linux/amd64/DEB/download: " + base + "minio_1_amd64.deb",
+			},
+		},
+		{
+			"relative same path", true,
+			`{"Linux":{"amd64":{"Binary":{"download":"` + base + `minio"}}}}`,
+			`{"Linux":{"amd64":{"Binary":{"download":"/server/minio/release/linux-amd64/minio"}}}}`,
+			nil,
+		},
+		{
+			"relative changed path", true,
+			`{"Linux":{"amd64":{"Binary":{"cksum":"` + base + `minio.sha256sum"}}}}`,
+			`{"Linux":{"amd64":{"Binary":{"cksum":"/server/minio/release/linux-amd64/minio.shasum"}}}}`,
+			[]string{"~ /Linux/amd64/Binary/cksum: /server/minio/release/linux-amd64/minio.sha256sum -> /server/minio/release/linux-amd64/minio.shasum"},
+		},
+	}
+	for _, tc := range testCases {
+		*relativeURLs = tc.relative
+		src := filepath.Join(t.TempDir(), "downloads-minio.json")
+		if err := os.WriteFile(src, []byte(tc.old), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := diffDownloads(src, []byte(tc.new))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: diff = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// The published document may be fetched too.
+	*relativeURLs = false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, testCases[1].old)
+	}))
+	defer srv.Close()
+	got, err := diffDownloads(srv.URL+"/downloads-minio.json", []byte(testCases[1].new))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, testCases[1].want) {
+		t.Errorf("fetched diff = %q, want %q", got, testCases[1].want)
+	}
+}
+
+func TestValidateURLs(t *testing.T) {
+	defer func(r bool) { *relativeURLs = r }(*relativeURLs)
+
+	const dir = "https://dl.min.io/server/minio/release/"
+	testCases := []struct {
+		name     string
+		relative bool
+		url      string
+		arch     string
+		version  string
+		archs    map[string]string
+		want     string
+	}{
+		{"valid binary", false, dir + "linux-amd64/minio", "amd64", "", nil, ""},
+		{"valid rpm", false, dir + "linux-arm64/minio-1.0.0-1.aarch64.rpm", "arm64", "1.0.0-1", rpmArchMap, ""},
+		{"valid relative", true, "/server/minio/release/linux-amd64/minio", "amd64", "", nil, ""},
+		{"deduped", false, dir + "linux-amd64/minio", "all", "", nil, ""},
+		{"no scheme", false, "dl.min.io/server/minio/release/linux-amd64/minio", "amd64", "", nil, "missing scheme or host"},
+		{"not relative", true, dir + "linux-amd64/minio", "amd64", "", nil, "not a root-relative URL"},
+		{"double slash", false, dir + "linux-amd64//minio", "amd64", "", nil, "double slash in path"},
+		{"no platform dir", false, "https://dl.min.io/server/minio/release/minio", "amd64", "", nil, "no <os>-<arch> directory"},
+		{"wrong arch dir", false, dir + "linux-arm64/minio", "amd64", "", nil, "directory is for arm64, entry is amd64"},
+		{"wrong version", false, dir + "linux-amd64/minio_2.0.0_amd64.deb", "amd64", "1.0.0", debArchMap, "version 1.0.0 missing from the file name"},
+		{"wrong package arch", false, dir + "linux-amd64/minio-1.0.0-1.aarch64.rpm", "amd64", "1.0.0-1", rpmArchMap, "no package arch name for amd64 in the file name"},
+	}
+	for _, tc := range testCases {
+		*relativeURLs = tc.relative
+		got := checkURL(tc.url, tc.arch, tc.version, tc.archs)
+		switch {
+		case tc.want == "" && len(got) > 0:
+			t.Errorf("%s: checkURL(%s) = %q, want no problems", tc.name, tc.url, got)
+		case tc.want != "" && !slices.Contains(got, tc.want):
+			t.Errorf("%s: checkURL(%s) = %q, want %q", tc.name, tc.url, got, tc.want)
+		}
+	}
+
+	*relativeURLs = false
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	if problems := validateURLs(d, "20240601000000.0.0"); len(problems) > 0 {
+		t.Errorf("generated downloads have problems: %q", problems)
+	}
+	// An amd64 entry linking to the arm64 rpm is flagged.
+	dl := d.Linux["MinIO Server"]["amd64"]
+	dl.RPM = d.Linux["MinIO Server"]["arm64"].RPM
+	d.Linux["MinIO Server"]["amd64"] = dl
+	problems := validateURLs(d, "20240601000000.0.0")
+	if len(problems) == 0 || !strings.HasPrefix(problems[0], "Linux/MinIO Server/amd64/RPM: ") {
+		t.Errorf("mismatched arch not flagged: %q", problems)
+	}
+}
+
+func TestMissingServiceFile(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(m *releaseManifest, d, s string) { manifest, *releaseDir, *serviceFile = m, d, s }(manifest, *releaseDir, *serviceFile)
+	manifest = &releaseManifest{App: "minio", Binaries: map[string]string{"amd64": bin}}
+	*releaseDir, *serviceFile = filepath.Join(dir, "out"), filepath.Join(dir, "minio.service")
+
+	testCases := []struct {
+		packager string
+		wantErr  bool
+	}{
+		{"deb", true},
+		{"rpm,apk", true},
+		// Only the systemd packagers ship the unit.
+		{"apk", false},
+	}
+	for _, tc := range testCases {
+		_, err := doPackage("minio", "RELEASE.2024-06-01T00-00-00Z", tc.packager)
+		if !tc.wantErr {
+			if err != nil {
+				t.Errorf("%s: %v", tc.packager, err)
+			}
+			continue
+		}
+		if err == nil || !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), "service file "+*serviceFile+" for the systemd unit of minio not found") {
+			t.Errorf("%s: error = %v, want the missing service file", tc.packager, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "linux-amd64", "minio_20240601000000.0.0_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("deb built without a service file")
+	}
+}
+
+func TestPrintVersionInfo(t *testing.T) {
+	testCases := []struct {
+		release string
+		want    []string
+	}{
+		{"RELEASE.2024-06-01T00-00-00Z", []string{
+			"Release time:  2024-06-01T00:00:00Z",
+			"SemVer:        20240601000000.0.0",
+			"RPM version:   20240601000000.0.0-1",
+			"DEB version:   20240601000000.0.0",
+			"APK version:   20240601000000.0.0",
+			"RPM (amd64):   minio-20240601000000.0.0-1.x86_64.rpm",
+			"DEB (arm64):   minio_20240601000000.0.0_arm64.deb",
+			"APK (arm64):   minio_20240601000000.0.0_aarch64.apk",
+		}},
+		{"RELEASE.2024-06-01T00-00-00Z.rc.1", []string{
+			"SemVer:        20240601000000.0.0-rc1",
+			"RPM version:   20240601000000.0.0-0.rc1",
+			"DEB version:   20240601000000.0.0~rc1",
+			"APK version:   20240601000000.0.0_rc1",
+			"RPM (arm64):   minio-20240601000000.0.0-0.rc1.aarch64.rpm",
+			"DEB (amd64):   minio_20240601000000.0.0~rc1_amd64.deb",
+			"APK (amd64):   minio_20240601000000.0.0_rc1_x86_64.apk",
+		}},
+	}
+	for _, tc := range testCases {
+		semVerTag, err := semVerRelease(tc.release)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		printVersionInfoTo(&b, "minio", tc.release, semVerTag)
+		lines := strings.Split(b.String(), "\n")
+		for _, want := range append([]string{"Release:       " + tc.release}, tc.want...) {
+			if !slices.Contains(lines, want) {
+				t.Errorf("%s: no line %q in:\n%s", tc.release, want, b.String())
+			}
+		}
+	}
+}
+
+func TestUnregisteredPackager(t *testing.T) {
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*releaseDir = filepath.Join(t.TempDir(), "out")
+
+	built, err := doPackage("minio", "RELEASE.2024-06-01T00-00-00Z", "deb,snap")
+	if err == nil || !strings.Contains(err.Error(), "packager snap not available") {
+		t.Errorf("error = %v, want packager snap not available", err)
+	}
+	if len(built) != 0 {
+		t.Errorf("built %d packages before failing", len(built))
+	}
+	// No package of a registered packager is built either.
+	if _, err = os.Stat(*releaseDir); !os.IsNotExist(err) {
+		t.Errorf("release directory %s created", *releaseDir)
+	}
+}
+
+func TestCombinedDownloadsJSON(t *testing.T) {
+	defer func(s []string) { *subscriptionNames = s }(*subscriptionNames)
+	*subscriptionNames = []string{"Enterprise"}
+
+	d := generateCombinedDownloadsJSON("20240601000000.0.0", "minio-enterprise")
+	buf, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(&d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged struct {
+		Linux         map[string]map[string]downloadJSON
+		Subscriptions map[string]downloadsJSON
+	}
+	if err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(buf, &merged); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		section string
+		arches  map[string]map[string]downloadJSON
+		product string
+		app     string
+	}{
+		{"community", merged.Linux, "MinIO Server", "minio"},
+		{"enterprise", merged.Subscriptions["Enterprise"].Linux, "AIStor Object Store", "minio-enterprise"},
+	}
+	for _, tc := range testCases {
+		arches, ok := tc.arches[tc.product]
+		if !ok {
+			t.Errorf("%s: no %s key in %s", tc.section, tc.product, buf)
+			continue
+		}
+		want := platformArches(tc.app, "linux")
+		if got := sortedKeys(arches); !slices.Equal(got, sortedKeys(toSet(want))) {
+			t.Errorf("%s: %s arches = %v, want %v", tc.section, tc.product, got, want)
+		}
+		for arch, dl := range arches {
+			if dl.Bin == nil || !strings.Contains(dl.Bin.Download, "/linux-"+arch+"/") {
+				t.Errorf("%s: %s %s binary = %+v", tc.section, tc.product, arch, dl.Bin)
+			}
+		}
+	}
+}
+
+// toSet returns the values of s as map keys.
+func toSet(s []string) map[string]bool {
+	m := make(map[string]bool, len(s))
+	for _, v := range s {
+		m[v] = true
+	}
+	return m
+}
+
+func TestOSFlag(t *testing.T) {
+	defer func(o string, m *releaseManifest) { *osName, manifest = o, m }(*osName, manifest)
+
+	// With a manifest only the --os binaries are listed.
+	manifest = &releaseManifest{App: "minio", Packagers: []string{"deb"}, Binaries: map[string]string{"amd64": "minio"}}
+	*osName = "linux"
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	if n := len(d.MacOS["MinIO Server"]) + len(d.Windows["MinIO Server"]); n != 0 {
+		t.Errorf("--os linux lists %d darwin and windows entries", n)
+	}
+	if _, ok := d.Linux["MinIO Server"]["amd64"]; !ok {
+		t.Errorf("--os linux drops the linux entry")
+	}
+	*osName = "darwin"
+	d = generateDownloadsJSON("20240601000000.0.0", "minio")
+	if len(d.Linux["MinIO Server"]) != 0 || len(d.MacOS["MinIO Server"]) != 1 {
+		t.Errorf("--os darwin lists linux %v and macOS %v", sortedKeys(d.Linux["MinIO Server"]), sortedKeys(d.MacOS["MinIO Server"]))
+	}
+	manifest = nil
+
+	// The source path and the package platform follow --os.
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*osName, *releaseDir = "freebsd", t.TempDir()
+	bin := platformBinary("mc", "RELEASE.2024-06-01T00-00-00Z", *osName, "amd64")
+	if want := filepath.Join(*releaseDir, "freebsd-amd64", "mc.RELEASE.2024-06-01T00-00-00Z"); bin != want {
+		t.Errorf("freebsd binary = %s, want %s", bin, want)
+	}
+	if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	manifest = &releaseManifest{App: "mc", Binaries: map[string]string{"amd64": bin}}
+	built, err := doPackage("mc", "RELEASE.2024-06-01T00-00-00Z", "deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 1 || filepath.Base(filepath.Dir(built[0].Path)) != "freebsd-amd64" {
+		t.Fatalf("built %+v, want a deb in freebsd-amd64", built)
+	}
+	if _, err = doPackage("mc", "RELEASE.2024-06-01T00-00-00Z", "apk"); err == nil || !strings.Contains(err.Error(), "does not support os freebsd") {
+		t.Errorf("apk for freebsd error = %v", err)
+	}
+}
+
+func TestPostHook(t *testing.T) {
+	defer func(h string, c bool) { *postHook, *postHookChecksums = h, c }(*postHook, *postHookChecksums)
+	t.Setenv("PKGER_HOOK_TEST", "inherited")
+
+	log := filepath.Join(t.TempDir(), "hook.log")
+	*postHook = `echo "$PKGER_HOOK_TEST {{.Path}}" >>` + log
+	*postHookChecksums = true
+	built := buildForTest(t, "deb,rpm")
+
+	buf, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, b := range built {
+		want = append(want, "inherited "+b.Path, "inherited "+b.Path+*checksumSuffix)
+	}
+	if got := strings.Split(strings.TrimSpace(string(buf)), "\n"); !slices.Equal(got, want) {
+		t.Errorf("hook ran for %q, want %q", got, want)
+	}
+
+	*postHook = "exit 3"
+	err = runPostHook(built[0].Path)
+	if err == nil || !strings.Contains(err.Error(), `post-hook "exit 3" failed for `+built[0].Path) {
+		t.Errorf("failing hook error = %v", err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("failing hook error = %v, want exit status 3", err)
+	}
+	// A failing hook fails the build.
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err = os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tryPackageBinary(t, "deb", bin, filepath.Join(dir, "out")); err == nil {
+		t.Errorf("doPackage succeeded with a failing hook")
+	}
+}
+
+func TestDedupeArches(t *testing.T) {
+	docker := downloadJSON{Text: "podman run quay.io/minio/minio"}
+	testCases := []struct {
+		name   string
+		arches map[string]downloadJSON
+		want   map[string]downloadJSON
+	}{
+		{
+			name:   "identical",
+			arches: map[string]downloadJSON{"amd64": docker, "arm64": docker, "ppc64le": docker},
+			want:   map[string]downloadJSON{"all": docker},
+		},
+		{
+			name: "differing",
+			arches: map[string]downloadJSON{
+				"amd64": {Bin: &dlInfo{Download: "https://dl.min.io/server/minio/release/linux-amd64/minio"}},
+				"arm64": {Bin: &dlInfo{Download: "https://dl.min.io/server/minio/release/linux-arm64/minio"}},
+			},
+			want: map[string]downloadJSON{
+				"amd64": {Bin: &dlInfo{Download: "https://dl.min.io/server/minio/release/linux-amd64/minio"}},
+				"arm64": {Bin: &dlInfo{Download: "https://dl.min.io/server/minio/release/linux-arm64/minio"}},
+			},
+		},
+		{
+			name:   "single",
+			arches: map[string]downloadJSON{"amd64": docker},
+			want:   map[string]downloadJSON{"amd64": docker},
+		},
+	}
+	for _, tc := range testCases {
+		d := downloadsJSON{Docker: map[string]map[string]downloadJSON{"Podman": tc.arches}}
+		dedupeArches(d)
+		if got := d.Docker["Podman"]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestChecksumSuffix(t *testing.T) {
+	defer func(s string) { *checksumSuffix = s }(*checksumSuffix)
+	*checksumSuffix = ".sha256"
+
+	built := buildForTest(t, "deb")
+	if _, err := os.Stat(built[0].Path + ".sha256"); err != nil {
+		t.Errorf("sidecar: %v", err)
+	}
+	if _, err := os.Stat(built[0].Path + ".sha256sum"); err == nil {
+		t.Errorf("sidecar written with the default suffix")
+	}
+
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	for platform, products := range d.platforms() {
+		for product, arches := range products {
+			for arch, dl := range arches {
+				for _, info := range []*dlInfo{dl.Bin, dl.RPM, dl.Deb, dl.APK} {
+					if info != nil && info.Checksum != info.Download+".sha256" {
+						t.Errorf("%s %s %s: checksum %s, want %s.sha256", platform, product, arch, info.Checksum, info.Download)
+					}
+				}
+			}
+		}
+	}
+}
+
+// releaseTree writes a minio amd64 binary, its systemd unit and a
+// manifest.yaml packaging it with packagers to a temporary directory
+// and returns the directory.
+func releaseTree(t *testing.T, packagers string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"minio":         "#!/bin/sh\n",
+		"minio.service": "[Unit]\nDescription=MinIO\n",
+		"manifest.yaml": fmt.Sprintf("app: minio\nrelease: RELEASE.2024-06-01T00-00-00Z\npackagers: [%s]\nbinaries:\n  amd64: %s\n", packagers, filepath.Join(dir, "minio")),
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runPkger runs pkger in dir on the releaseTree there with args, the
+// packages and metadata go to dir/out, and returns its combined output.
+func runPkger(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	args = append([]string{"--manifest-in", "manifest.yaml", "--service-file", "minio.service", "-d", "out"}, args...)
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PKGER_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestProfiles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "success"},
+		{name: "fatal", args: []string{"--dedupe-json", "--json-layout", "arch"}, wantErr: true},
+	} {
+		dir := releaseTree(t, "deb")
+		args := append([]string{"--cpuprofile", "cpu.pprof", "--memprofile", "mem.pprof"}, tc.args...)
+		if out, err := runPkger(t, dir, args...); (err != nil) != tc.wantErr {
+			t.Fatalf("%s: pkger err = %v, wantErr %v\n%s", tc.name, err, tc.wantErr, out)
+		}
+		for _, name := range []string{"cpu.pprof", "mem.pprof"} {
+			fi, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			} else if fi.Size() == 0 {
+				t.Errorf("%s: %s is empty", tc.name, name)
+			}
+		}
+	}
+}
+
+func TestBinaryMode(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	// Release binaries copied without their mode must still be executable.
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	built := packageBinary(t, "deb,apk", bin, filepath.Join(dir, "out"))
+	hdr, ok := debHeaders(t, built[0].Path)["/usr/local/bin/minio"]
+	if !ok {
+		t.Fatal("deb does not install /usr/local/bin/minio")
+	}
+	if mode := hdr.FileInfo().Mode().Perm(); mode != 0o755 {
+		t.Errorf("deb /usr/local/bin/minio mode = %o, want 755", mode)
+	}
+	var found bool
+	walkAPK(t, built[1].Path, func(th *tar.Header, _ []byte) {
+		if path.Clean(th.Name) != "usr/local/bin/minio" {
+			return
+		}
+		found = true
+		if mode := th.FileInfo().Mode().Perm(); mode != 0o755 {
+			t.Errorf("apk /usr/local/bin/minio mode = %o, want 755", mode)
+		}
+	})
+	if !found {
+		t.Error("apk does not install /usr/local/bin/minio")
+	}
+}
+
+func TestLatestJSON(t *testing.T) {
+	defer func(d string) { *jsonDir = d }(*jsonDir)
+	*jsonDir = t.TempDir()
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {"amd64": {Bin: &dlInfo{Download: "https://dl.min.io/server/minio/release/linux-amd64/minio"}}},
+		},
+		MacOS: map[string]map[string]downloadJSON{
+			"MinIO Server": {"arm64": {Bin: &dlInfo{Download: "https://dl.min.io/server/minio/release/darwin-arm64/minio"}}},
+		},
+		Docker: map[string]map[string]downloadJSON{
+			"Podman": {"amd64": {Bin: &dlInfo{Download: "quay.io/minio/minio"}}},
+		},
+	}
+	if err := writeLatestJSON(release, d); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(filepath.Join(*jsonDir, "latest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got latestDownloadsJSON
+	if err = jsoniter.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := latestDownloadsJSON{
+		SchemaVersion: downloadsSchemaVersion,
+		Release:       release,
+		Downloads: map[string]map[string]string{
+			// Docker has no os-arch binary and is left out.
+			"MinIO Server": {
+				"linux-amd64":  "https://dl.min.io/server/minio/release/linux-amd64/minio",
+				"darwin-arm64": "https://dl.min.io/server/minio/release/darwin-arm64/minio",
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("latest.json = %+v, want %+v", got, want)
+	}
+}
+
+func TestAPKNoSystemdUnit(t *testing.T) {
+	defer func(f string) { *openrcFile = f }(*openrcFile)
+	*openrcFile = filepath.Join(t.TempDir(), "minio.initd")
+	if err := os.WriteFile(*openrcFile, []byte("#!/sbin/openrc-run\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	built := buildForTest(t, "deb,apk")
+	if _, ok := debFiles(t, built[0].Path)["/lib/systemd/system/minio.service"]; !ok {
+		t.Error("deb does not ship the systemd unit")
+	}
+	apk := apkFiles(t, built[1].Path)
+	for name := range apk {
+		if strings.Contains(name, "systemd") {
+			t.Errorf("apk ships %s", name)
+		}
+	}
+	if got := apk["etc/init.d/minio"]; got != "#!/sbin/openrc-run\n" {
+		t.Errorf("apk /etc/init.d/minio = %q", got)
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	built := []builtPackage{
+		{Packager: "deb", Arch: "amd64", Size: 100},
+		{Packager: "rpm", Arch: "amd64", Size: 200},
+		{Packager: "deb", Arch: "arm64", Size: 300},
+	}
+	testCases := []struct {
+		built    []builtPackage
+		jsonPath string
+		want     string
+	}{
+		{built, "minio-release/downloads-minio.json", "Summary: 3 packages built across 2 arches, 600 bytes total, metadata at minio-release/downloads-minio.json\n"},
+		{built, "", "Summary: 3 packages built across 2 arches, 600 bytes total\n"},
+		{nil, "", "Summary: 0 packages built across 0 arches, 0 bytes total\n"},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		printSummary(&buf, tc.built, tc.jsonPath)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestEmbedChecksums(t *testing.T) {
+	built := buildForTest(t, "deb")
+	buf, err := os.ReadFile(built[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf)
+
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	embedChecksums(d, built)
+	dl := d.Linux["MinIO Server"]["amd64"]
+	if want := hex.EncodeToString(sum[:]); dl.Deb.SHA256 != want {
+		t.Errorf("amd64 DEB sha256 = %q, want %q", dl.Deb.SHA256, want)
+	}
+	// Packages not built in this run get no digest.
+	if dl.RPM.SHA256 != "" || d.Linux["MinIO Server"]["arm64"].Deb.SHA256 != "" {
+		t.Errorf("digest embedded for a package not built in this run")
+	}
+}
+
+func TestChannels(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	if out, err := runPkger(t, dir, "--channels", "release,edge"); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	for name, segment := range map[string]string{
+		"downloads-minio.json":      "/release/",
+		"downloads-minio-edge.json": "/edge/",
+	} {
+		buf, err := os.ReadFile(filepath.Join(dir, "out", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d downloadsJSON
+		if err = jsoniter.Unmarshal(buf, &d); err != nil {
+			t.Fatal(err)
+		}
+		dl := d.Linux["MinIO Server"]["amd64"]
+		if !strings.Contains(dl.Bin.Download, segment) || !strings.Contains(dl.Deb.Checksum, segment) {
+			t.Errorf("%s: amd64 URLs %s, %s not under %s", name, dl.Bin.Download, dl.Deb.Checksum, segment)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "out", name+".sha256sum")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyVersion(t *testing.T) {
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	dir := t.TempDir()
+	testCases := []struct {
+		name, version string
+		wantErr       bool
+	}{
+		{"match", "minio version " + release, false},
+		{"mismatch", "minio version RELEASE.2024-05-01T00-00-00Z", true},
+	}
+	for _, tc := range testCases {
+		bin := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\necho '"+tc.version+"'\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		err := verifyVersion(bin, runtime.GOARCH, release)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+		if tc.wantErr && err != nil && !strings.Contains(err.Error(), "expected "+release) {
+			t.Errorf("%s: err = %v", tc.name, err)
+		}
+	}
+
+	// A binary of another arch is skipped rather than run.
+	other := "amd64"
+	if runtime.GOARCH == other {
+		other = "arm64"
+	}
+	if err := verifyVersion(filepath.Join(dir, "mismatch"), other, release); err != nil {
+		t.Errorf("foreign arch: %v", err)
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	defer func(e int64) { *sourceDateEpoch = e }(*sourceDateEpoch)
+	*sourceDateEpoch = 1717200000
+	epoch := time.Unix(*sourceDateEpoch, 0)
+
+	var sums [2]string
+	for i := range sums {
+		dir := t.TempDir()
+		bin := filepath.Join(dir, "minio")
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// Every build picks up sources with another mtime.
+		mtime := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(bin, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		built := packageBinary(t, "deb", bin, filepath.Join(dir, "out"))
+		for name, hdr := range debHeaders(t, built[0].Path) {
+			if !hdr.ModTime.Equal(epoch) {
+				t.Errorf("build %d: %s mtime = %v, want %v", i, name, hdr.ModTime, epoch)
+			}
+		}
+		sums[i] = built[0].SHA256
+	}
+	if sums[0] == "" || sums[0] != sums[1] {
+		t.Errorf("rebuild sha256 %s differs from %s", sums[1], sums[0])
+	}
+}
+
+func TestDlPath(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	if out, err := runPkger(t, dir, "--dl-path", "minio=/server/myminio/"); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	buf, err := os.ReadFile(filepath.Join(dir, "out", "downloads-minio.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf, []byte("/server/minio/")) {
+		t.Errorf("downloads JSON still references /server/minio/")
+	}
+	var d downloadsJSON
+	if err = jsoniter.Unmarshal(buf, &d); err != nil {
+		t.Fatal(err)
+	}
+	dl := d.Linux["MinIO Server"]["amd64"]
+	for _, s := range []string{dl.Bin.Download, dl.Bin.Checksum, dl.Deb.Download, dl.Deb.Text} {
+		if !strings.Contains(s, "https://dl.min.io/server/myminio/release/linux-amd64/") {
+			t.Errorf("%q does not use the overridden path", s)
+		}
+	}
+
+	out, err := runPkger(t, dir, "--dl-path", "miniox=server/x")
+	if err == nil || !strings.Contains(out, "unknown app miniox for --dl-path") {
+		t.Errorf("unknown app: err = %v\n%s", err, out)
+	}
+}
+
+func TestPlatformArchesMatrix(t *testing.T) {
+	defer func(arches []string) { appPlatformArches["minio"]["darwin"] = arches }(appPlatformArches["minio"]["darwin"])
+	appPlatformArches["minio"]["darwin"] = []string{"arm64"}
+
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	if got := sortedKeys(d.MacOS["MinIO Server"]); !slices.Equal(got, []string{"arm64"}) {
+		t.Errorf("macOS arches = %v, want [arm64]", got)
+	}
+	if dl := d.MacOS["MinIO Server"]["arm64"]; dl.Bin == nil || !strings.Contains(dl.Bin.Download, "/darwin-arm64/") {
+		t.Errorf("macOS arm64 entry = %+v", dl)
+	}
+	if got := sortedKeys(d.Linux["MinIO Server"]); !slices.Equal(got, appPlatformArches["minio"]["linux"]) {
+		t.Errorf("linux arches = %v, want %v", got, appPlatformArches["minio"]["linux"])
+	}
+}
+
+// captureStderr returns what fn writes to os.Stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer func(stderr *os.File) { os.Stderr = stderr }(os.Stderr)
+	os.Stderr = f
+	fn()
+	buf, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestWarnCollisions(t *testing.T) {
+	defer func(w bool, e int64) { *warnCollisions, *sourceDateEpoch = w, e }(*warnCollisions, *sourceDateEpoch)
+	*warnCollisions = true
+	*sourceDateEpoch = 1717200000
+
+	dir := t.TempDir()
+	bin, out := filepath.Join(dir, "minio"), filepath.Join(dir, "out")
+	testCases := []struct {
+		name, contents string
+		wantWarning    bool
+	}{
+		{"first build", "#!/bin/sh\n", false},
+		{"identical rebuild", "#!/bin/sh\n", false},
+		{"changed rebuild", "#!/bin/sh\necho changed\n", true},
+	}
+	for _, tc := range testCases {
+		if err := os.WriteFile(bin, []byte(tc.contents), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var built []builtPackage
+		stderr := captureStderr(t, func() { built = packageBinary(t, "deb", bin, out) })
+		want := "warning: " + built[0].Path + " was rebuilt with a different checksum"
+		if got := strings.Contains(stderr, want); got != tc.wantWarning {
+			t.Errorf("%s: warned = %v, want %v\n%s", tc.name, got, tc.wantWarning, stderr)
+		}
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	defer func(s []string) { *symlinkFlags = s }(*symlinkFlags)
+
+	testCases := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"/usr/local/bin/minio:/usr/bin/minio", false},
+		{"/usr/local/bin/minio", true},
+		{":/usr/bin/minio", true},
+		{"/usr/local/bin/minio:", true},
+	}
+	for _, tc := range testCases {
+		if _, err := parseSymlinks([]string{tc.value}); (err != nil) != tc.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tc.value, err, tc.wantErr)
+		}
+	}
+
+	*symlinkFlags = []string{"/usr/local/bin/minio:/usr/bin/minio"}
+	hdr, ok := debHeaders(t, packageForTest(t, "deb"))["/usr/bin/minio"]
+	if !ok {
+		t.Fatal("deb has no /usr/bin/minio")
+	}
+	if hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "/usr/local/bin/minio" {
+		t.Errorf("/usr/bin/minio type %c -> %q, want a symlink to /usr/local/bin/minio", hdr.Typeflag, hdr.Linkname)
+	}
+}
+
+func TestSHA256FileStreams(t *testing.T) {
+	const size = 64 << 20
+	f, err := os.Create(filepath.Join(t.TempDir(), "large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sparse, reads back as zeros.
+	if err = f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	want := sha256.New()
+	if _, err = io.CopyN(want, zeroReader{}, size); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	got, err := sha256File(f.Name())
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != hex.EncodeToString(want.Sum(nil)) {
+		t.Errorf("sha256 = %s, want %s", got, hex.EncodeToString(want.Sum(nil)))
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > size/16 {
+		t.Errorf("hashing a %d byte file allocated %d bytes", size, alloc)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestPrintTrace(t *testing.T) {
+	defer func(v bool) { *traceFlag = v }(*traceFlag)
+	*traceFlag = true
+
+	built := buildForTest(t, "deb,rpm")
+	for _, b := range built {
+		if b.Render <= 0 || b.Package <= 0 || b.Checksum <= 0 {
+			t.Errorf("%s: durations not recorded: %+v", b.Packager, b)
+		}
+	}
+
+	var buf bytes.Buffer
+	printTrace(&buf, built)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1+len(built) {
+		t.Fatalf("got %d lines, want %d\n%s", len(lines), 1+len(built), buf.String())
+	}
+	if got := strings.Fields(lines[0]); !slices.Equal(got, []string{"ARCH", "PACKAGER", "RENDER", "PACKAGE", "CHECKSUM", "INSTALLED", "SIZE"}) {
+		t.Errorf("header = %q", lines[0])
+	}
+	for i, b := range built {
+		fields := strings.Fields(lines[i+1])
+		if len(fields) != 6 || fields[0] != "amd64" || fields[1] != b.Packager || fields[5] != strconv.FormatInt(b.InstalledSize, 10) {
+			t.Errorf("row %d = %q", i, lines[i+1])
+		}
+	}
+}
+
+func TestAttachSig(t *testing.T) {
+	defer func(s string) { *attachSig = s }(*attachSig)
+	const sig = "untrusted comment: signature from minisign secret key\nRWQ=\n"
+	*attachSig = filepath.Join(t.TempDir(), "minio.minisig")
+	if err := os.WriteFile(*attachSig, []byte(sig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	built := buildForTest(t, "deb,apk")
+	if got := debFiles(t, built[0].Path)["/usr/share/minio/minio.minisig"]; got != sig {
+		t.Errorf("deb minisig = %q, want %q", got, sig)
+	}
+	if got := apkFiles(t, built[1].Path)["usr/share/minio/minio.minisig"]; got != sig {
+		t.Errorf("apk minisig = %q, want %q", got, sig)
+	}
+}
+
+func TestWriteTextInstructions(t *testing.T) {
+	defer func(d string) { *jsonDir = d }(*jsonDir)
+	*jsonDir = t.TempDir()
+
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {
+				"arm64": {Bin: &dlInfo{Text: "wget arm64/minio"}},
+				"amd64": {Bin: &dlInfo{Text: "wget amd64/minio"}, Deb: &dlInfo{Text: "dpkg -i minio.deb"}},
+			},
+		},
+		Docker: map[string]map[string]downloadJSON{
+			"Podman": {"amd64": {Text: "podman run quay.io/minio/minio"}},
+		},
+	}
+	if err := writeTextInstructions(d); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(*jsonDir, "install-minio.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `## MinIO Server - Linux (amd64)
+
+### Binary
+
+wget amd64/minio
+
+### DEB
+
+dpkg -i minio.deb
+
+## MinIO Server - Linux (arm64)
+
+### Binary
+
+wget arm64/minio
+
+## Podman - Docker (amd64)
+
+podman run quay.io/minio/minio
+
+`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJSONDir(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	if out, err := runPkger(t, dir, "--json-dir", "meta", "--latest-json"); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	for _, name := range []string{"downloads-minio.json", "downloads-minio.json.sha256sum", "latest.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "meta", name)); err != nil {
+			t.Errorf("%s not in --json-dir: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "out", name)); err == nil {
+			t.Errorf("%s also written to the release directory", name)
+		}
+	}
+	// The packages stay in the release directory.
+	if _, err := os.Stat(filepath.Join(dir, "out", "linux-amd64", "minio_20240601000000.0.0_amd64.deb")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWindowsCRLF(t *testing.T) {
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {"amd64": {Bin: &dlInfo{Text: "wget minio\nchmod +x minio"}}},
+		},
+		Windows: map[string]map[string]downloadJSON{
+			"MinIO Server": {"amd64": {
+				Text: "a\nb",
+				Bin:  &dlInfo{Text: "Invoke-WebRequest minio.exe\r\nC:\\minio.exe server F:\\Data\n"},
+			}},
+		},
+	}
+	windowsCRLF(d)
+	win := d.Windows["MinIO Server"]["amd64"]
+	if win.Text != "a\r\nb" {
+		t.Errorf("windows text = %q", win.Text)
+	}
+	// Existing CRLFs are not doubled.
+	if want := "Invoke-WebRequest minio.exe\r\nC:\\minio.exe server F:\\Data\r\n"; win.Bin.Text != want {
+		t.Errorf("windows binary text = %q, want %q", win.Bin.Text, want)
+	}
+	if got := d.Linux["MinIO Server"]["amd64"].Bin.Text; got != "wget minio\nchmod +x minio" {
+		t.Errorf("linux text rewritten to %q", got)
+	}
+}
+
+func TestDlEnvStaging(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	if out, err := runPkger(t, dir, "--dl-env", "staging", "--latest-json"); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	for _, name := range []string{"downloads-minio.json", "latest.json"} {
+		buf, err := os.ReadFile(filepath.Join(dir, "out", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(buf, []byte("://dl.min.io/")) || bytes.Contains(buf, []byte("://dl.minio.io/")) {
+			t.Errorf("%s references the prod mirror", name)
+		}
+		if !bytes.Contains(buf, []byte("https://dl-staging.min.io/server/minio/release/linux-amd64/minio")) {
+			t.Errorf("%s does not reference the staging mirror", name)
+		}
+	}
+}
+
+func TestScriptsExecutable(t *testing.T) {
+	defer func(d string) { *scriptsDir = d }(*scriptsDir)
+	*scriptsDir = t.TempDir()
+	script := filepath.Join(*scriptsDir, "postinstall.sh")
+	// Copied from a checkout without its mode and shebang.
+	if err := os.WriteFile(script, []byte("echo installed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var built []builtPackage
+	stderr := captureStderr(t, func() { built = buildForTest(t, "deb,apk") })
+	if want := "warning: script " + script + " has no shebang (#!)"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr %q does not contain %q", stderr, want)
+	}
+	if mode := debHeaders(t, built[0].Path)["control/postinst"].FileInfo().Mode().Perm(); mode != 0o755 {
+		t.Errorf("deb postinst mode = %o, want 755", mode)
+	}
+	var found bool
+	walkAPK(t, built[1].Path, func(th *tar.Header, _ []byte) {
+		if th.Name == ".post-install" {
+			found = true
+			if mode := th.FileInfo().Mode().Perm(); mode != 0o755 {
+				t.Errorf("apk .post-install mode = %o, want 755", mode)
+			}
+		}
+	})
+	if !found {
+		t.Error("apk has no .post-install")
+	}
+}
+
+func TestDigestFile(t *testing.T) {
+	defer func(v bool) { *digestFile = v }(*digestFile)
+	*digestFile = true
+
+	for _, b := range buildForTest(t, "deb,rpm") {
+		got, err := os.ReadFile(b.Path + ".digest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, err := sha256File(b.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "sha256:" + sum + "\n"; string(got) != want {
+			t.Errorf("%s digest = %q, want %q", b.Packager, got, want)
+		}
+	}
+}
+
+func TestDefaultScripts(t *testing.T) {
+	defer func(d string, n bool) { *scriptsDir, *noDefaultScripts = d, n }(*scriptsDir, *noDefaultScripts)
+	*scriptsDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(*scriptsDir, "postinstall.sh"), []byte("#!/bin/sh\necho custom\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	built := buildForTest(t, "deb,rpm,apk")
+	deb := debFiles(t, built[0].Path)
+	if got := deb["control/postinst"]; !strings.Contains(got, "echo custom") || strings.Contains(got, "daemon-reload") {
+		t.Errorf("deb postinst = %q, want the --scripts-dir one", got)
+	}
+	if got := deb["control/prerm"]; !strings.Contains(got, "systemctl disable --now 'minio.service'") {
+		t.Errorf("deb prerm = %q, want the default one", got)
+	}
+	if _, ok := deb["control/postrm"]; ok {
+		t.Errorf("deb has a default postrm")
+	}
+	// apk packages ship no systemd unit, the scripts would manage none.
+	apk := apkFiles(t, built[2].Path)
+	if got := apk[".post-install"]; !strings.Contains(got, "echo custom") {
+		t.Errorf("apk .post-install = %q, want the --scripts-dir one", got)
+	}
+	if _, ok := apk[".pre-deinstall"]; ok {
+		t.Errorf("apk has the default preremove")
+	}
+
+	*scriptsDir = ""
+	deb = debFiles(t, packageForTest(t, "deb"))
+	if got := deb["control/postinst"]; !strings.Contains(got, "systemctl daemon-reload") {
+		t.Errorf("deb postinst = %q, want the default one", got)
+	}
+
+	*noDefaultScripts = true
+	deb = debFiles(t, packageForTest(t, "deb"))
+	for _, name := range []string{"control/postinst", "control/prerm"} {
+		if _, ok := deb[name]; ok {
+			t.Errorf("--no-default-scripts: deb has %s", name)
+		}
+	}
+}
+
+func TestDefaultScriptsWithoutService(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "mc")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(m *releaseManifest, d string) { manifest, *releaseDir = m, d }(manifest, *releaseDir)
+	manifest = &releaseManifest{App: "mc", Binaries: map[string]string{"amd64": bin}}
+	*releaseDir = filepath.Join(dir, "out")
+
+	built, err := doPackage("mc", "RELEASE.2024-06-01T00-00-00Z", "deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range debFiles(t, built[0].Path) {
+		if name == "control/postinst" || name == "control/prerm" {
+			t.Errorf("mc deb has %s without a systemd unit", name)
+		}
+	}
+}
+
+func TestDefaultPreRemove(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := defaultServiceScripts(pkgScripts{}, "minio.service", dir); err != nil {
+		t.Fatal(err)
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not installed")
+	}
+	// Upgrades exit before looking for systemctl, an empty PATH makes
+	// every other action skip it too.
+	for _, action := range []string{"remove", "upgrade", "0", "1"} {
+		cmd := exec.Command(sh, filepath.Join(dir, "preremove.sh"), action)
+		cmd.Env = []string{"PATH=" + dir}
+		if out, err := cmd.CombinedOutput(); err != nil || len(out) > 0 {
+			t.Errorf("%s: err = %v, output %q", action, err, out)
+		}
+	}
+}
+
+func TestAddArchNotes(t *testing.T) {
+	d := enterpriseDownloadsJSON{Subscriptions: map[string]downloadsJSON{
+		"Enterprise": {
+			Linux: map[string]map[string]downloadJSON{
+				"AIStor Server": {"amd64": {}, "arm64": {}},
+			},
+			Docker: map[string]map[string]downloadJSON{
+				"Podman": {"arm64": {Text: "podman run"}},
+			},
+		},
+	}}
+	addArchNotes(d, map[string]string{"arm64": "Requires ARMv8.2", "riscv64": "unused"})
+
+	sub := d.Subscriptions["Enterprise"]
+	if got := sub.Linux["AIStor Server"]["amd64"].Note; got != "" {
+		t.Errorf("amd64 note = %q, want none", got)
+	}
+	for _, dl := range []downloadJSON{sub.Linux["AIStor Server"]["arm64"], sub.Docker["Podman"]["arm64"]} {
+		if dl.Note != "Requires ARMv8.2" {
+			t.Errorf("arm64 note = %q, want %q", dl.Note, "Requires ARMv8.2")
+		}
+	}
+	if _, ok := sub.Linux["AIStor Server"]["riscv64"]; ok {
+		t.Errorf("note added an entry for riscv64")
+	}
+}
+
+func TestDebCompressionNone(t *testing.T) {
+	defer func(c string) { *debCompression = c }(*debCompression)
+	*debCompression = "none"
+
+	pkgPath := packageForTest(t, "deb")
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var members []string
+	r := ar.NewReader(f)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, strings.TrimSuffix(hdr.Name, "/"))
+	}
+	if !slices.Contains(members, "data.tar") {
+		t.Errorf("deb members %q, want an uncompressed data.tar", members)
+	}
+	// The data is still readable.
+	if _, ok := debFiles(t, pkgPath)["/usr/local/bin/minio"]; !ok {
+		t.Errorf("data.tar has no /usr/local/bin/minio")
+	}
+}
+
+func TestChangelogSection(t *testing.T) {
+	const md = `# Changelog
+
+## RELEASE.2024-06-01T00-00-00Z
+
+- Fix healing of objects
+### Security
+- Bump Go
+
+## RELEASE.2024-05-01T00-00-00Z
+
+- Add tiering
+`
+	testCases := []struct {
+		release string
+		want    string
+		ok      bool
+	}{
+		{"RELEASE.2024-06-01T00-00-00Z", "- Fix healing of objects\n### Security\n- Bump Go", true},
+		{"RELEASE.2024-05-01T00-00-00Z", "- Add tiering", true},
+		{"RELEASE.2024-04-01T00-00-00Z", "", false},
+		// A section spans the lower level headings below it.
+		{"Changelog", "## RELEASE.2024-06-01T00-00-00Z\n\n- Fix healing of objects\n### Security\n- Bump Go\n\n## RELEASE.2024-05-01T00-00-00Z\n\n- Add tiering", true},
+	}
+	for _, tc := range testCases {
+		got, ok := changelogSection(md, tc.release)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s: got %q, %v, want %q, %v", tc.release, got, ok, tc.want, tc.ok)
+		}
+	}
+	// Headings need a space after the hashes.
+	if _, ok := changelogSection("#RELEASE.2024-06-01T00-00-00Z\ntext\n", "RELEASE.2024-06-01T00-00-00Z"); ok {
+		t.Errorf("matched a line without a space after the hashes")
+	}
+}
+
+func TestExpandExtraFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"docs/a.md", "docs/b.md", "docs/c.txt", "docs/sub/d.md", "LICENSE"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		value   string
+		want    []pkgFile
+		wantErr bool
+	}{
+		{
+			value: filepath.Join(dir, "LICENSE") + ":/usr/share/doc/minio/LICENSE",
+			want:  []pkgFile{{Src: filepath.Join(dir, "LICENSE"), Dst: "/usr/share/doc/minio/LICENSE"}},
+		},
+		{
+			value: filepath.Join(dir, "docs", "*.md") + ":/usr/share/doc/minio",
+			want: []pkgFile{
+				{Src: filepath.Join(dir, "docs", "a.md"), Dst: "/usr/share/doc/minio/a.md"},
+				{Src: filepath.Join(dir, "docs", "b.md"), Dst: "/usr/share/doc/minio/b.md"},
+			},
+		},
+		{
+			// Matches keep their path below the glob's leading
+			// directory.
+			value: filepath.Join(dir, "docs", "*", "*.md") + ":/usr/share/doc/minio",
+			want:  []pkgFile{{Src: filepath.Join(dir, "docs", "sub", "d.md"), Dst: "/usr/share/doc/minio/sub/d.md"}},
+		},
+		{value: filepath.Join(dir, "docs", "*.rst") + ":/usr/share/doc/minio", wantErr: true},
+		{value: filepath.Join(dir, "docs", "[") + ":/usr/share/doc/minio", wantErr: true},
+		{value: filepath.Join(dir, "LICENSE"), wantErr: true},
+	}
+	for _, tc := range testCases {
+		got, err := expandExtraFiles([]string{tc.value})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tc.value, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadsJSONSidecar(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	for i := 0; i < 2; i++ {
+		// The rerun replaces both files.
+		if out, err := runPkger(t, dir, "--channels", "release,edge"); err != nil {
+			t.Fatalf("pkger: %v\n%s", err, out)
+		}
+	}
+	for _, name := range []string{"downloads-minio.json", "downloads-minio-edge.json"} {
+		jsonPath := filepath.Join(dir, "out", name)
+		sum, err := sha256File(jsonPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sidecar, err := os.ReadFile(jsonPath + ".sha256sum")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := sum + "  " + name; string(sidecar) != want {
+			t.Errorf("%s sidecar = %q, want %q", name, sidecar, want)
+		}
+	}
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), "downloads-minio") {
+			t.Errorf("unexpected file %s", e.Name())
+		}
+	}
+}
+
+func TestVCSRef(t *testing.T) {
+	defer func(r string) { *vcsRef = r }(*vcsRef)
+	*vcsRef = "3f2a1c9d8e7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+
+	built := buildForTest(t, "deb,rpm")
+	control := debFiles(t, built[0].Path)["control/control"]
+	if !strings.Contains(control, "\nVcs-Ref: "+*vcsRef+"\n") {
+		t.Errorf("deb control has no Vcs-Ref field:\n%s", control)
+	}
+	if !strings.Contains(control, "Built from commit "+*vcsRef+".") {
+		t.Errorf("deb description does not mention the commit:\n%s", control)
+	}
+	// RPMTAG_DESCRIPTION, shown by rpm -qi.
+	if desc := rpmHeaderStrings(t, built[1].Path, 1005); len(desc) != 1 || !strings.Contains(desc[0], "Built from commit "+*vcsRef+".") {
+		t.Errorf("rpm description %q does not mention the commit", desc)
+	}
+}
+
+func TestUpdateFeed(t *testing.T) {
+	feedPath := filepath.Join(t.TempDir(), "feed.xml")
+	releases := []string{"RELEASE.2024-05-01T00-00-00Z", "RELEASE.2024-06-01T10-20-30Z", "RELEASE.2024-05-01T00-00-00Z"}
+	for _, release := range releases {
+		if err := updateFeed(feedPath, "minio", release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := os.ReadFile(feedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed atomFeed
+	if err = xml.Unmarshal(buf, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Updated != "2024-06-01T10:20:30Z" {
+		t.Errorf("feed updated = %s, want 2024-06-01T10:20:30Z", feed.Updated)
+	}
+	// The newest entry comes first, re-adding a release is a no-op.
+	want := []atomEntry{
+		{
+			Title:   "minio RELEASE.2024-06-01T10-20-30Z",
+			ID:      "https://dl.min.io/server/minio/release/RELEASE.2024-06-01T10-20-30Z",
+			Updated: "2024-06-01T10:20:30Z",
+			Link:    atomLink{Href: "https://dl.min.io/server/minio/release/"},
+		},
+		{
+			Title:   "minio RELEASE.2024-05-01T00-00-00Z",
+			ID:      "https://dl.min.io/server/minio/release/RELEASE.2024-05-01T00-00-00Z",
+			Updated: "2024-05-01T00:00:00Z",
+			Link:    atomLink{Href: "https://dl.min.io/server/minio/release/"},
+		},
+	}
+	if !reflect.DeepEqual(feed.Entries, want) {
+		t.Errorf("entries = %+v, want %+v", feed.Entries, want)
+	}
+
+	if err = updateFeed(feedPath, "minio", "not-a-release"); err == nil {
+		t.Errorf("invalid release tag accepted")
+	}
+}
+
+func TestLicenseExpr(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"AGPL-3.0", false},
+		{"AGPL-3.0 OR Commercial", false},
+		{"(AGPL-3.0-only OR LicenseRef-Commercial) AND Apache-2.0", false},
+		{"GPL-2.0-or-later WITH Classpath-exception-2.0", false},
+		{"AGPL-3.0 OR", true},
+		{"OR AGPL-3.0", true},
+		{"(AGPL-3.0 OR Commercial", true},
+		{"AGPL-3.0)", true},
+		{"AGPL-3.0 Commercial", true},
+		{"GPL-2.0 WITH", true},
+		{"GNU AGPL v3", true},
+	}
+	for _, tc := range testCases {
+		if err := checkLicenseExpr(tc.expr); (err != nil) != tc.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tc.expr, err, tc.wantErr)
+		}
+	}
+}
+
+func TestLicense(t *testing.T) {
+	defer func(l, m string) { *license, *metaFile = l, m }(*license, *metaFile)
+
+	// --meta licenses are free form and not validated.
+	*metaFile = filepath.Join(t.TempDir(), "meta.yaml")
+	if err := os.WriteFile(*metaFile, []byte("license: GNU AGPL v3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// RPMTAG_LICENSE
+	if got := rpmHeaderStrings(t, packageForTest(t, "rpm"), 1014); !slices.Equal(got, []string{"GNU AGPL v3"}) {
+		t.Errorf("--meta license = %q, want GNU AGPL v3", got)
+	}
+
+	*license = "(AGPL-3.0-only OR LicenseRef-Commercial)"
+	built := buildForTest(t, "rpm,apk")
+	if got := rpmHeaderStrings(t, built[0].Path, 1014); !slices.Equal(got, []string{*license}) {
+		t.Errorf("rpm license = %q, want %q", got, *license)
+	}
+	if pkginfo := apkFiles(t, built[1].Path)[".PKGINFO"]; !strings.Contains(pkginfo, "\nlicense = "+*license+"\n") {
+		t.Errorf("apk .PKGINFO has no license %q:\n%s", *license, pkginfo)
+	}
+
+	*license = "AGPL-3.0 OR"
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tryPackageBinary(t, "rpm", bin, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "invalid license expression") {
+		t.Errorf("invalid --license: err = %v", err)
+	}
+}
+
+func TestNoticeFile(t *testing.T) {
+	defer func(f string) { *noticeFile = f }(*noticeFile)
+	const notice = "github.com/klauspost/compress\nBSD-3-Clause\n"
+	*noticeFile = filepath.Join(t.TempDir(), "NOTICE")
+	if err := os.WriteFile(*noticeFile, []byte(notice), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgPath := packageForTest(t, "deb")
+	hdr, ok := debHeaders(t, pkgPath)["/usr/share/doc/minio/THIRD-PARTY"]
+	if !ok {
+		t.Fatal("deb has no /usr/share/doc/minio/THIRD-PARTY")
+	}
+	// World readable whatever the mode of the source.
+	if mode := hdr.FileInfo().Mode().Perm(); mode != 0o644 {
+		t.Errorf("THIRD-PARTY mode = %o, want 644", mode)
+	}
+	if got := debFiles(t, pkgPath)["/usr/share/doc/minio/THIRD-PARTY"]; got != notice {
+		t.Errorf("THIRD-PARTY = %q, want %q", got, notice)
+	}
+}
+
+func TestKubectlMinioDownloadsJSON(t *testing.T) {
+	const semVerTag = "20240601000000.0.0"
+	d := generateKubectlMinioDownloadsJSON(semVerTag)
+	for _, arch := range platformArches("kubectl-minio", "linux") {
+		dl := d.Linux["MinIO Operator"][arch]
+		if dl.Bin == nil || dl.RPM == nil || dl.Deb == nil {
+			t.Errorf("%s: missing binary, rpm or deb entry: %+v", arch, dl)
+		}
+		if dl.APK == nil {
+			t.Errorf("%s: no APK entry", arch)
+			continue
+		}
+		want := fmt.Sprintf("https://dl.min.io/operator/kubectl-minio/release/linux-%s/kubectl-minio_%s_%s.apk", arch, apkVersion(semVerTag), apkArchMap[arch])
+		if dl.APK.Download != want || dl.APK.Checksum != want+*checksumSuffix {
+			t.Errorf("%s: APK %s, %s, want %s", arch, dl.APK.Download, dl.APK.Checksum, want)
+		}
+		if !strings.Contains(dl.APK.Text, "apk add --allow-untrusted "+path.Base(want)) {
+			t.Errorf("%s: APK text %q", arch, dl.APK.Text)
+		}
+	}
+	for _, platform := range []map[string]map[string]downloadJSON{d.MacOS, d.Windows} {
+		for arch, dl := range platform["MinIO Operator"] {
+			if dl.Bin == nil || dl.APK != nil {
+				t.Errorf("%s: %+v, want only a binary", arch, dl)
+			}
+		}
+	}
+}
+
+func TestAPKDownloads(t *testing.T) {
+	const semVerTag = "20240601000000.0.0"
+	enterprise := func(appName string) []downloadsJSON {
+		var ds []downloadsJSON
+		for _, sd := range generateEnterpriseDownloadsJSON(semVerTag, appName).Subscriptions {
+			ds = append(ds, sd)
+		}
+		return ds
+	}
+	testCases := []struct {
+		appName string
+		product string
+		segment string
+		ds      []downloadsJSON
+	}{
+		{"minio", "MinIO Server", "server/minio", []downloadsJSON{generateDownloadsJSON(semVerTag, "minio")}},
+		{"mc", "MinIO Client", "client/mc", []downloadsJSON{generateDownloadsJSON(semVerTag, "mc")}},
+		{"minio-enterprise", "AIStor Object Store", "aistor/minio", enterprise("minio-enterprise")},
+		{"mc-enterprise", "AIStor MinIO Client", "aistor/mc", enterprise("mc-enterprise")},
+	}
+	for _, testCase := range testCases {
+		if len(testCase.ds) == 0 {
+			t.Errorf("%s: no downloads", testCase.appName)
+		}
+		for _, d := range testCase.ds {
+			for _, arch := range platformArches(testCase.appName, "linux") {
+				dl := d.Linux[testCase.product][arch]
+				if dl.APK == nil {
+					t.Errorf("%s: %s: no APK entry", testCase.appName, arch)
+					continue
+				}
+				want := fmt.Sprintf("https://dl.min.io/%s/release/linux-%s/%s_%s_%s.apk", testCase.segment, arch, packageName(testCase.appName), apkVersion(semVerTag), apkArchMap[arch])
+				if dl.APK.Download != want || dl.APK.Checksum != want+*checksumSuffix {
+					t.Errorf("%s: %s: APK %s, %s, want %s", testCase.appName, arch, dl.APK.Download, dl.APK.Checksum, want)
+				}
+			}
+		}
+	}
+
+	defer delete(appCapsTable, "minio-enterprise")
+	appCapsTable["minio-enterprise"] = appCapabilities{Packages: true, JSON: true, Packagers: []string{"deb", "rpm"}}
+	for _, d := range enterprise("minio-enterprise") {
+		for arch, dl := range d.Linux["AIStor Object Store"] {
+			if dl.APK != nil {
+				t.Errorf("%s: APK entry %s for an app not packaged as apk", arch, dl.APK.Download)
+			}
+			if dl.Deb == nil {
+				t.Errorf("%s: no deb entry", arch)
+			}
+		}
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	for name, d := range map[string]any{
+		"community":  generateDownloadsJSON("20240601000000.0.0", "minio"),
+		"enterprise": generateEnterpriseDownloadsJSON("20240601000000.0.0", "minio-enterprise"),
+	} {
+		buf, err := json.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := jsonToYAML(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The JSON field names are kept.
+		if !bytes.Contains(y, []byte("cksum:")) || bytes.Contains(y, []byte("checksum:")) {
+			t.Errorf("%s: YAML does not use the JSON field names", name)
+		}
+		back, err := yamlToJSON(y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := reflect.New(reflect.TypeOf(d))
+		if err = json.Unmarshal(back, got.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), d) {
+			t.Errorf("%s: YAML round trip changed the downloads", name)
+		}
+	}
+}
+
+func TestFileModes(t *testing.T) {
+	defer func(m string) { *metaFile = m }(*metaFile)
+	*metaFile = filepath.Join(t.TempDir(), "meta.yaml")
+	if err := os.WriteFile(*metaFile, []byte("file_modes:\n  /lib/systemd/system/minio.service: \"0600\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := debHeaders(t, packageForTest(t, "deb"))
+	if mode := headers["/lib/systemd/system/minio.service"].FileInfo().Mode().Perm(); mode != 0o600 {
+		t.Errorf("service file mode = %o, want 600", mode)
+	}
+	// Entries not listed keep their mode.
+	if mode := headers["/usr/local/bin/minio"].FileInfo().Mode().Perm(); mode != 0o755 {
+		t.Errorf("binary mode = %o, want 755", mode)
+	}
+
+	for _, mode := range []string{"rw-r--r--", "10644", "0999"} {
+		if err := os.WriteFile(*metaFile, []byte("file_modes:\n  /lib/systemd/system/minio.service: \""+mode+"\"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadMeta(*metaFile); err == nil {
+			t.Errorf("mode %q accepted", mode)
+		}
+	}
+}
+
+func TestPackagesOnly(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	// sidekick is marked packages-only in appCapsTable.
+	manifest := fmt.Sprintf("app: sidekick\nrelease: RELEASE.2024-06-01T00-00-00Z\npackagers: [deb]\nbinaries:\n  amd64: %s\n", filepath.Join(dir, "minio"))
+	if err := os.WriteFile(filepath.Join(dir, "manifest.yaml"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runPkger(t, dir, "--latest-json", "--checksums-json"); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	var debs, jsons []string
+	filepath.WalkDir(filepath.Join(dir, "out"), func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case strings.HasSuffix(p, ".deb"):
+			debs = append(debs, p)
+		case strings.Contains(filepath.Base(p), ".json"):
+			jsons = append(jsons, p)
+		}
+		return nil
+	})
+	if len(debs) == 0 {
+		t.Errorf("no package built")
+	}
+	if len(jsons) > 0 {
+		t.Errorf("packages-only app wrote %q", jsons)
+	}
+}
+
+func TestPackagerFlag(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	// --packager keeps its enum validation.
+	out, err := runPkger(t, dir, "--packager", "snap")
+	if err == nil || !strings.Contains(out, "enum value must be one of deb,rpm,apk,archlinux,ipk,deb,rpm,apk, got 'snap'") {
+		t.Errorf("--packager snap: err = %v\n%s", err, out)
+	}
+}
+
+func TestIndentText(t *testing.T) {
+	testCases := []struct {
+		name, in, want string
+	}{
+		{"single line", "MinIO object storage", "  MinIO object storage"},
+		{"flush", "MinIO\nobject storage", "  MinIO\n  object storage"},
+		{"raw string continuation", "MinIO\n\t\tobject storage\n\t\tfor AI", "  MinIO\n  object storage\n  for AI"},
+		{"nested", "MinIO\n   - one\n     two", "  MinIO\n  - one\n    two"},
+		{"blank lines", "MinIO\n\n   storage\n   \n", "  MinIO\n\n  storage"},
+	}
+	for _, tc := range testCases {
+		got := indentText(2, tc.in)
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		// The result is a valid block scalar with the text unindented.
+		var v struct{ Description string }
+		if err := yaml.Unmarshal([]byte("description: |\n"+got+"\nname: minio\n"), &v); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if want := strings.ReplaceAll(tc.want, "\n  ", "\n")[2:] + "\n"; v.Description != want {
+			t.Errorf("%s: parsed %q, want %q", tc.name, v.Description, want)
+		}
+	}
+}
+
+// writeTestDeb writes a deb to pkgPath with the given ar members, each
+// a gzipped tar of the files keyed by name with their mode.
+func writeTestDeb(t *testing.T, pkgPath string, members map[string]map[string]int64) {
+	t.Helper()
+	f, err := os.Create(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := ar.NewWriter(f)
+	if err = w.WriteGlobalHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteHeader(&ar.Header{Name: "debian-binary", Mode: 0o644, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("2.0\n"))
+	for _, member := range sortedKeys(members) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		for _, name := range sortedKeys(members[member]) {
+			body := []byte("#!/bin/sh\n")
+			if path.Base(name) == "control" {
+				body = []byte("Package: minio\nVersion: 1.0.0\nMaintainer: Unset Maintainer <unset@localhost>\n")
+			}
+			if err = tw.WriteHeader(&tar.Header{Name: name, Mode: members[member][name], Size: int64(len(body))}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write(body)
+		}
+		tw.Close()
+		zw.Close()
+		if err = w.WriteHeader(&ar.Header{Name: member, Mode: 0o644, Size: int64(buf.Len())}); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(buf.Bytes())
+	}
+}
+
+func TestLintDeb(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name    string
+		members map[string]map[string]int64
+		want    []string
+	}{
+		{
+			name: "bad",
+			members: map[string]map[string]int64{
+				"control.tar.gz": {"./control": 0o644},
+				"data.tar.gz": {
+					"./usr/local/bin/minio": 0o777,
+					"./srv/minio/minio":     0o755,
+				},
+			},
+			want: []string{
+				"missing Description in control",
+				"unset Maintainer in control",
+				"/srv/minio/minio is outside of /usr/, /etc/, /lib/, /var/, /opt/",
+				"/usr/local/bin/minio is world-writable",
+			},
+		},
+		{
+			name: "no data",
+			members: map[string]map[string]int64{
+				"control.tar.gz": {"./md5sums": 0o644},
+			},
+			want: []string{"no control file", "no data archive"},
+		},
+	}
+	for _, tc := range testCases {
+		pkgPath := filepath.Join(dir, tc.name+".deb")
+		writeTestDeb(t, pkgPath, tc.members)
+		got, err := lintDeb(pkgPath)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		sort.Strings(got)
+		sort.Strings(tc.want)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: warnings %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// A package built by pkger passes.
+	if got, err := lintDeb(packageForTest(t, "deb")); err != nil || len(got) > 0 {
+		t.Errorf("pkger deb: warnings %q, err %v", got, err)
+	}
+}
+
+func TestChecksumsJSON(t *testing.T) {
+	defer func(d string) { *jsonDir = d }(*jsonDir)
+	*jsonDir = t.TempDir()
+
+	const semVerTag = "20240601000000.0.0"
+	if err := writeChecksumsJSON(generateDownloadsJSON(semVerTag, "minio")); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(filepath.Join(*jsonDir, "checksums.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sums map[string]map[string]map[string]string
+	if err = jsoniter.Unmarshal(buf, &sums); err != nil {
+		t.Fatal(err)
+	}
+
+	const base = "https://dl.min.io/server/minio/release/"
+	want := map[string]string{
+		"binary": base + "linux-amd64/minio.sha256sum",
+		"rpm":    base + "linux-amd64/minio-" + rpmVersion(semVerTag) + ".x86_64.rpm.sha256sum",
+		"deb":    base + "linux-amd64/minio_" + debVersion(semVerTag) + "_amd64.deb.sha256sum",
+		"apk":    base + "linux-amd64/minio_" + apkVersion(semVerTag) + "_x86_64.apk.sha256sum",
+	}
+	if got := sums["MinIO Server"]["linux-amd64"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("linux-amd64 = %v, want %v", got, want)
+	}
+	for osArch, want := range map[string]map[string]string{
+		"darwin-arm64":  {"binary": base + "darwin-arm64/minio.sha256sum"},
+		"windows-amd64": {"binary": base + "windows-amd64/minio.exe.sha256sum"},
+	} {
+		if got := sums["MinIO Server"][osArch]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", osArch, got, want)
+		}
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	defer func(v bool) { *incremental = v }(*incremental)
+	*incremental = true
+
+	dir := t.TempDir()
+	bin, out := filepath.Join(dir, "minio"), filepath.Join(dir, "out")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	touch := func(p string, mtime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(bin, now.Add(-2*time.Hour))
+	first := packageBinary(t, "deb", bin, out)[0]
+	// Older than now, newer than the input.
+	built := now.Add(-time.Hour)
+	touch(first.Path, built)
+
+	testCases := []struct {
+		name        string
+		input       time.Time
+		wantRebuild bool
+	}{
+		{"unchanged input", now.Add(-2 * time.Hour), false},
+		{"touched input", now, true},
+	}
+	for _, tc := range testCases {
+		touch(bin, tc.input)
+		b := packageBinary(t, "deb", bin, out)[0]
+		fi, err := os.Stat(b.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rebuilt := !fi.ModTime().Equal(built); rebuilt != tc.wantRebuild {
+			t.Errorf("%s: rebuilt = %v, want %v", tc.name, rebuilt, tc.wantRebuild)
+		}
+		if b.SHA256 == "" || b.Packager != "deb" || b.Arch != "amd64" {
+			t.Errorf("%s: built %+v", tc.name, b)
+		}
+	}
+}
+
+func TestJSONOnlyTag(t *testing.T) {
+	dir := releaseTree(t, "deb")
+	if out, err := runPkger(t, dir, "--json-only-tag", "RELEASE.2024-07-01T00-00-00Z"); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	buf, err := os.ReadFile(filepath.Join(dir, "out", "downloads-minio.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d downloadsJSON
+	if err = jsoniter.Unmarshal(buf, &d); err != nil {
+		t.Fatal(err)
+	}
+	if got := path.Base(d.Linux["MinIO Server"]["amd64"].Deb.Download); got != "minio_20240701000000.0.0_amd64.deb" {
+		t.Errorf("deb download %s is not for the --json-only-tag release", got)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "out", "linux-amd64")); err == nil {
+		t.Errorf("packages were built")
+	}
+
+	out, err := runPkger(t, dir, "--json-only-tag", "v1.0.0")
+	if err == nil {
+		t.Errorf("invalid --json-only-tag accepted\n%s", out)
+	}
+}
+
+func TestAPKArchNames(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(m *releaseManifest, d string) { manifest, *releaseDir = m, d }(manifest, *releaseDir)
+	manifest = &releaseManifest{App: "minio", Binaries: map[string]string{"amd64": bin, "arm64": bin, "ppc64le": bin}}
+	*releaseDir = filepath.Join(dir, "out")
+
+	built, err := doPackage("minio", "RELEASE.2024-06-01T00-00-00Z", "apk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 3 {
+		t.Fatalf("built %d apks, want 3", len(built))
+	}
+	for _, b := range built {
+		want := fmt.Sprintf("minio_%s_%s.apk", apkVersion("20240601000000.0.0"), apkArchMap[b.Arch])
+		if got := filepath.Base(b.Path); got != want {
+			t.Errorf("%s: apk %s, want %s", b.Arch, got, want)
+		}
+		if pkginfo := apkFiles(t, b.Path)[".PKGINFO"]; !strings.Contains(pkginfo, "\narch = "+apkArchMap[b.Arch]+"\n") {
+			t.Errorf("%s: .PKGINFO has no arch %s:\n%s", b.Arch, apkArchMap[b.Arch], pkginfo)
+		}
+	}
+}
+
+func TestSystemdDir(t *testing.T) {
+	defer func(d string) { *systemdDir = d }(*systemdDir)
+
+	testCases := []struct {
+		dir  string
+		want string
+	}{
+		{"/lib/systemd/system", "/lib/systemd/system/minio.service"},
+		{"/usr/lib/systemd/system/", "/usr/lib/systemd/system/minio.service"},
+	}
+	for _, testCase := range testCases {
+		*systemdDir = testCase.dir
+		files := debFiles(t, packageForTest(t, "deb"))
+		if _, ok := files[testCase.want]; !ok {
+			t.Errorf("%s: deb does not ship %s", testCase.dir, testCase.want)
+		}
+		for name := range files {
+			if strings.HasSuffix(name, "/minio.service") && name != testCase.want {
+				t.Errorf("%s: deb ships the unit as %s", testCase.dir, name)
+			}
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	const semVerTag = "20240601000000.0.0"
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	testCases := []struct {
+		name string
+		d    any
+	}{
+		{"community", generateDownloadsJSON(semVerTag, "minio")},
+		{"enterprise", generateEnterpriseDownloadsJSON(semVerTag, "minio-enterprise")},
+		{"combined", generateCombinedDownloadsJSON(semVerTag, "minio")},
+		{"kubectl-minio", generateKubectlMinioDownloadsJSON(semVerTag)},
+		{"arch", pivotByArch(generateDownloadsJSON(semVerTag, "minio"))},
+	}
+	for _, testCase := range testCases {
+		buf, err := json.Marshal(testCase.d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			SchemaVersion *int
+			Subscriptions map[string]struct{ SchemaVersion *int }
+		}
+		if err = json.Unmarshal(buf, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.SchemaVersion == nil || *doc.SchemaVersion != downloadsSchemaVersion {
+			t.Errorf("%s: SchemaVersion %v, want %d", testCase.name, doc.SchemaVersion, downloadsSchemaVersion)
+		}
+		for subscription, sd := range doc.Subscriptions {
+			if sd.SchemaVersion == nil || *sd.SchemaVersion != downloadsSchemaVersion {
+				t.Errorf("%s: %s: SchemaVersion %v, want %d", testCase.name, subscription, sd.SchemaVersion, downloadsSchemaVersion)
+			}
+		}
+	}
+}
+
+func TestVersionedInstall(t *testing.T) {
+	defer func(v bool) { *versionedInstall = v }(*versionedInstall)
+	*versionedInstall = true
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	hdrs := debHeaders(t, packageForTest(t, "deb"))
+	bin, ok := hdrs["/usr/local/bin/minio-"+release]
+	if !ok {
+		t.Fatalf("deb does not ship /usr/local/bin/minio-%s", release)
+	}
+	if bin.Typeflag != tar.TypeReg || bin.Mode&0o777 != 0o755 {
+		t.Errorf("/usr/local/bin/minio-%s type %c mode %o, want a 0755 file", release, bin.Typeflag, bin.Mode)
+	}
+	link, ok := hdrs["/usr/local/bin/minio"]
+	if !ok {
+		t.Fatal("deb does not ship /usr/local/bin/minio")
+	}
+	if link.Typeflag != tar.TypeSymlink || link.Linkname != "minio-"+release {
+		t.Errorf("/usr/local/bin/minio type %c -> %q, want a symlink to minio-%s", link.Typeflag, link.Linkname, release)
+	}
+}
+
+func TestWriteArchManifests(t *testing.T) {
+	dir := t.TempDir()
+	binaries := make(map[string]string)
+	for _, arch := range []string{"amd64", "arm64"} {
+		bin := filepath.Join(dir, arch, "minio")
+		if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\necho "+arch+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		binaries[arch] = bin
+	}
+	service := filepath.Join(dir, "minio.service")
+	if err := os.WriteFile(service, []byte("[Unit]\nDescription=MinIO\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(m *releaseManifest, d, s string) { manifest, *releaseDir, *serviceFile = m, d, s }(manifest, *releaseDir, *serviceFile)
+	manifest = &releaseManifest{App: "minio", Binaries: binaries}
+	*releaseDir = filepath.Join(dir, "out")
+	*serviceFile = service
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	built, err := doPackage("minio", release, "deb,apk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeArchManifests("minio", release, built); err != nil {
+		t.Fatal(err)
+	}
+
+	for arch, bin := range binaries {
+		want := make(map[string]string)
+		sum, err := sha256File(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want["minio"] = sum
+		archDir := ""
+		for _, b := range built {
+			if b.Arch == arch {
+				want[filepath.Base(b.Path)] = b.SHA256
+				archDir = filepath.Dir(b.Path)
+			}
+		}
+		if len(want) != 3 {
+			t.Fatalf("%s: built %d packages, want 2", arch, len(want)-1)
+		}
+		buf, err := os.ReadFile(filepath.Join(archDir, "SHA256SUMS"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+			sum, name, ok := strings.Cut(line, "  ")
+			if !ok {
+				t.Fatalf("%s: malformed SHA256SUMS line %q", arch, line)
+			}
+			got[name] = sum
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: SHA256SUMS = %v, want %v", arch, got, want)
+		}
+	}
+}
+
+func TestRunExternalLint(t *testing.T) {
+	defer func(v bool) { *externalLintWarnings = v }(*externalLintWarnings)
+
+	// The linters log their arguments and exit with $LINT_EXIT.
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "log")
+	for _, name := range []string{"lintian", "rpmlint"} {
+		script := fmt.Sprintf("#!/bin/sh\necho %s \"$@\" >>%s\nexit ${LINT_EXIT:-0}\n", name, log)
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		packager string
+		warnings bool
+		exit     string
+		path     string
+		want     string
+		wantErr  bool
+	}{
+		{"deb", false, "0", bin, "lintian --fail-on error minio.pkg", false},
+		{"deb", true, "0", bin, "lintian --fail-on error,warning minio.pkg", false},
+		{"rpm", false, "0", bin, "rpmlint minio.pkg", false},
+		{"rpm", true, "0", bin, "rpmlint --strict minio.pkg", false},
+		{"deb", false, "1", bin, "lintian --fail-on error minio.pkg", true},
+		{"rpm", false, "1", bin, "rpmlint minio.pkg", true},
+		{"apk", false, "1", bin, "", false},
+		// A linter that is not installed is skipped.
+		{"deb", false, "1", t.TempDir(), "", false},
+	}
+	for _, tc := range testCases {
+		os.Remove(log)
+		t.Setenv("PATH", tc.path)
+		t.Setenv("LINT_EXIT", tc.exit)
+		*externalLintWarnings = tc.warnings
+		err := runExternalLint(builtPackage{Packager: tc.packager, Path: "minio.pkg"})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s warnings %v exit %s: err = %v, wantErr %v", tc.packager, tc.warnings, tc.exit, err, tc.wantErr)
+		}
+		buf, _ := os.ReadFile(log)
+		if got := strings.TrimSpace(string(buf)); got != tc.want {
+			t.Errorf("%s warnings %v: ran %q, want %q", tc.packager, tc.warnings, got, tc.want)
+		}
+	}
+}
+
+func TestArchSuffixSource(t *testing.T) {
+	defer func(v bool) { *archSuffixSource = v }(*archSuffixSource)
+	defer func(d, s string) { *releaseDir, *serviceFile = d, s }(*releaseDir, *serviceFile)
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	dir := t.TempDir()
+	*releaseDir = filepath.Join(dir, "out")
+	*serviceFile = filepath.Join(dir, "minio.service")
+	if err := os.WriteFile(*serviceFile, []byte("[Unit]\nDescription=MinIO\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	arches := platformArches("minio", "linux")
+	for _, arch := range arches {
+		bin := filepath.Join(*releaseDir, "linux-"+arch, "minio."+release+"-"+arch)
+		if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\necho "+arch+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	*archSuffixSource = false
+	if got, want := platformBinary("minio", release, "linux", "amd64"), filepath.Join(*releaseDir, "linux-amd64", "minio."+release); got != want {
+		t.Errorf("binary %s, want %s", got, want)
+	}
+	if _, err := doPackage("minio", release, "deb"); err == nil {
+		t.Error("packaged the arch-suffixed binaries without --arch-suffix-source")
+	}
+
+	*archSuffixSource = true
+	if got, want := platformBinary("minio", release, "linux", "amd64"), filepath.Join(*releaseDir, "linux-amd64", "minio."+release+"-amd64"); got != want {
+		t.Errorf("binary %s, want %s", got, want)
+	}
+	built, err := doPackage("minio", release, "deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != len(arches) {
+		t.Fatalf("built %d debs, want %d", len(built), len(arches))
+	}
+	for _, b := range built {
+		if got, want := debFiles(t, b.Path)["/usr/local/bin/minio"], "#!/bin/sh\necho "+b.Arch+"\n"; got != want {
+			t.Errorf("%s: /usr/local/bin/minio = %q, want %q", b.Arch, got, want)
+		}
+	}
+}
+
+func TestDiffAgainstWritesNothing(t *testing.T) {
+	published := releaseTree(t, "deb")
+	if out, err := runPkger(t, published); err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+
+	dir := releaseTree(t, "deb")
+	out, err := runPkger(t, dir, "--diff-against", filepath.Join(published, "out", "downloads-minio.json"),
+		"--per-arch-manifest", "--cas-out", "cas", "--checksums-index", "--latest-json", "--checksums-json", "--text-instructions")
+	if err != nil {
+		t.Fatalf("pkger: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "0 URL changes") {
+		t.Errorf("no diff printed:\n%s", out)
+	}
+	for _, name := range []string{"out", "cas"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("--diff-against wrote %s: %v", name, err)
+		}
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	defer func(s []string) { *subscriptionNames = s }(*subscriptionNames)
+	*subscriptionNames = []string{"Enterprise", "Standard"}
+
+	for _, appName := range []string{"minio-enterprise", "mc-enterprise"} {
+		d := generateEnterpriseDownloadsJSON("20240601000000.0.0", appName)
+		if len(d.Subscriptions) != 2 {
+			t.Fatalf("%s: %d subscriptions, want 2", appName, len(d.Subscriptions))
+		}
+		enterprise, standard := d.Subscriptions["Enterprise"], d.Subscriptions["Standard"]
+		if len(enterprise.Linux) == 0 {
+			t.Errorf("%s: no Linux downloads", appName)
+		}
+		if !reflect.DeepEqual(enterprise, standard) {
+			t.Errorf("%s: subscriptions differ:\n%+v\n%+v", appName, enterprise, standard)
+		}
+		// The subscriptions do not share their maps.
+		for product, arches := range enterprise.Linux {
+			if _, ok := arches["amd64"]; !ok {
+				continue
+			}
+			delete(arches, "amd64")
+			if _, ok := standard.Linux[product]["amd64"]; !ok {
+				t.Errorf("%s: %s downloads are shared between subscriptions", appName, product)
+			}
+		}
+	}
+}
+
+func TestRelativizeURLs(t *testing.T) {
+	d := downloadsJSON{
+		Linux: map[string]map[string]downloadJSON{
+			"MinIO Server": {"amd64": {
+				Bin: &dlInfo{
+					Download: "https://dl.min.io/server/minio/release/linux-amd64/minio",
+					Checksum: "https://dl.min.io/server/minio/release/linux-amd64/minio.sha256sum",
+					Text:     "wget https://dl.min.io/server/minio/release/linux-amd64/minio",
+				},
+				Deb: &dlInfo{
+					Download: "http://dl-staging.min.io/server/minio/release/linux-amd64/minio_20240601000000.0.0_amd64.deb",
+					Checksum: "/server/minio/release/linux-amd64/minio_20240601000000.0.0_amd64.deb.sha256sum",
+				},
+			}},
+		},
+		MacOS: map[string]map[string]downloadJSON{
+			"MinIO Server": {"arm64": {Homebrew: &dlInfo{Text: "brew install minio/stable/minio"}}},
+		},
+	}
+	relativizeURLs(d)
+
+	bin := d.Linux["MinIO Server"]["amd64"].Bin
+	if bin.Download != "/server/minio/release/linux-amd64/minio" || bin.Checksum != "/server/minio/release/linux-amd64/minio.sha256sum" {
+		t.Errorf("binary URLs %s, %s are not root-relative", bin.Download, bin.Checksum)
+	}
+	if bin.Text != "wget https://dl.min.io/server/minio/release/linux-amd64/minio" {
+		t.Errorf("install text %q is not kept absolute", bin.Text)
+	}
+	deb := d.Linux["MinIO Server"]["amd64"].Deb
+	if deb.Download != "/server/minio/release/linux-amd64/minio_20240601000000.0.0_amd64.deb" ||
+		deb.Checksum != "/server/minio/release/linux-amd64/minio_20240601000000.0.0_amd64.deb.sha256sum" {
+		t.Errorf("deb URLs %s, %s are not root-relative", deb.Download, deb.Checksum)
+	}
+	if brew := d.MacOS["MinIO Server"]["arm64"].Homebrew; brew.Download != "" || brew.Text != "brew install minio/stable/minio" {
+		t.Errorf("Homebrew %+v changed", brew)
+	}
+}
+
+func TestIPK(t *testing.T) {
+	untgz := func(buf []byte) (names []string, files map[string]string) {
+		t.Helper()
+		zr, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = make(map[string]string)
+		tr := tar.NewReader(zr)
+		for {
+			th, err := tr.Next()
+			if err == io.EOF {
+				return names, files
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, th.Name)
+			files[th.Name] = string(body)
+		}
+	}
+
+	pkgPath := packageForTest(t, "ipk")
+	if got, want := filepath.Base(pkgPath), "minio_20240601000000.0.0_x86_64.ipk"; got != want {
+		t.Errorf("ipk %s, want %s", got, want)
+	}
+	buf, err := os.ReadFile(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, outer := untgz(buf)
+	if want := []string{"./debian-binary", "./control.tar.gz", "./data.tar.gz"}; !slices.Equal(names, want) {
+		t.Fatalf("ipk members %q, want %q", names, want)
+	}
+	if outer["./debian-binary"] != "2.0\n" {
+		t.Errorf("debian-binary = %q", outer["./debian-binary"])
+	}
+	_, control := untgz([]byte(outer["./control.tar.gz"]))
+	for _, field := range []string{"Package: minio\n", "Version: 20240601000000.0.0\n", "Architecture: x86_64\n"} {
+		if !strings.Contains(control["./control"], field) {
+			t.Errorf("control has no %q:\n%s", field, control["./control"])
+		}
+	}
+	_, data := untgz([]byte(outer["./data.tar.gz"]))
+	if data["./usr/local/bin/minio"] != "#!/bin/sh\n" {
+		t.Errorf("data has no /usr/local/bin/minio: %q", sortedKeys(data))
+	}
+	for name := range data {
+		if strings.Contains(name, "systemd") {
+			t.Errorf("ipk ships %s", name)
+		}
+	}
+}
+
+func TestDefaultsDirConffiles(t *testing.T) {
+	defer func(d string) { *defaultsDir = d }(*defaultsDir)
+	*defaultsDir = t.TempDir()
+	files := map[string]string{
+		"config.env":       "MINIO_VOLUMES=/mnt/data\n",
+		"certs/public.crt": "-----BEGIN CERTIFICATE-----\n",
+	}
+	for name, contents := range files {
+		p := filepath.Join(*defaultsDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deb := debFiles(t, packageForTest(t, "deb"))
+	conffiles := strings.Fields(deb["control/conffiles"])
+	for name, contents := range files {
+		dst := "/etc/minio/" + name
+		if got := deb[dst]; got != contents {
+			t.Errorf("%s = %q, want %q", dst, got, contents)
+		}
+		if !slices.Contains(conffiles, dst) {
+			t.Errorf("conffiles %q do not list %s", conffiles, dst)
+		}
+	}
+	if slices.Contains(conffiles, "/usr/local/bin/minio") {
+		t.Errorf("conffiles %q list the binary", conffiles)
+	}
+}
+
+// testSignKey writes a throwaway armored PGP private key and returns
+// its path along with the keyring to verify its signatures.
+func testSignKey(t *testing.T) (string, openpgp.EntityList) {
+	t.Helper()
+	e, err := openpgp.NewEntity("pkger test", "", "test@example.com", &packet.Config{RSABits: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "key.asc")
+	if err = os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p, openpgp.EntityList{e}
+}
+
+// rpmSignatures returns the binary signature tags of the rpm in buf by
+// tag, along with the main header they sign.
+func rpmSignatures(t *testing.T, buf []byte) (map[uint32][]byte, []byte) {
+	t.Helper()
+	// section returns the entries of the header section at off and
+	// its length.
+	section := func(off int) (map[uint32][]byte, int) {
+		if len(buf) < off+16 || !bytes.Equal(buf[off:off+4], []byte{0x8e, 0xad, 0xe8, 0x01}) {
+			t.Fatalf("no rpm header at offset %d", off)
+		}
+		nindex := int(binary.BigEndian.Uint32(buf[off+8:]))
+		hsize := int(binary.BigEndian.Uint32(buf[off+12:]))
+		store := buf[off+16+nindex*16 : off+16+nindex*16+hsize]
+		entries := make(map[uint32][]byte)
+		for i := 0; i < nindex; i++ {
+			e := buf[off+16+i*16:]
+			tag, typ := binary.BigEndian.Uint32(e), binary.BigEndian.Uint32(e[4:])
+			offset, count := binary.BigEndian.Uint32(e[8:]), binary.BigEndian.Uint32(e[12:])
+			if typ == 7 { // RPM_BIN_TYPE
+				entries[tag] = store[offset : offset+count]
+			}
+		}
+		return entries, 16 + nindex*16 + hsize
+	}
+	const leadSize = 96
+	sigs, n := section(leadSize)
+	off := leadSize + n
+	off += (8 - off%8) % 8
+	_, n = section(off)
+	return sigs, buf[off : off+n]
+}
+
+func TestSignedRPM(t *testing.T) {
+	defer func(k string) { *signKey = k }(*signKey)
+	var keyring openpgp.EntityList
+	*signKey, keyring = testSignKey(t)
+
+	buf, err := os.ReadFile(packageForTest(t, "rpm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, header := rpmSignatures(t, buf)
+	const sigRSA, sigPGP = 268, 1002
+	sig, ok := sigs[sigRSA]
+	if !ok {
+		t.Fatal("rpm has no header signature")
+	}
+	if _, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(header), bytes.NewReader(sig), nil); err != nil {
+		t.Errorf("header signature does not verify: %v", err)
+	}
+	if _, ok = sigs[sigPGP]; !ok {
+		t.Errorf("rpm has no header and payload signature")
+	}
+}
+
+func TestSignedDeb(t *testing.T) {
+	defer func(k, typ string) { *signKey, *debSignatureType = k, typ }(*signKey, *debSignatureType)
+	var keyring openpgp.EntityList
+	*signKey, keyring = testSignKey(t)
+
+	for _, sigType := range []string{"origin", "maint"} {
+		*debSignatureType = sigType
+		f, err := os.Open(packageForTest(t, "deb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		var signed, sig []byte
+		r := ar.NewReader(f)
+		for {
+			hdr, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := strings.TrimSuffix(hdr.Name, "/")
+			names = append(names, name)
+			if strings.HasPrefix(name, "_gpg") {
+				sig = buf
+			} else {
+				signed = append(signed, buf...)
+			}
+		}
+		f.Close()
+		if got := names[len(names)-1]; got != "_gpg"+sigType {
+			t.Fatalf("%s: deb members %q, want _gpg%s last", sigType, names, sigType)
+		}
+		if _, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(sig), nil); err != nil {
+			t.Errorf("%s: signature does not verify: %v", sigType, err)
+		}
+	}
+}
+
+func TestPackageEpoch(t *testing.T) {
+	const semVerTag = "20240601000000.0.0"
+	testCases := []struct {
+		prev    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"20240501000000.0.0", "", false},
+		{semVerTag, "", false},
+		{"20240701000000.0.0", "1", false},
+		{"2:20240501000000.0.0", "2", false},
+		{"2:20240701000000.0.0", "3", false},
+		{"20240601000000.0.0-rc1", "", false},
+		{"x:20240501000000.0.0", "", true},
+		{"-1:20240501000000.0.0", "", true},
+	}
+	for _, tc := range testCases {
+		got, err := packageEpoch(semVerTag, tc.prev)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tc.prev, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: epoch %q, want %q", tc.prev, got, tc.want)
+		}
+	}
+
+	defer func(v string) { *prevVersion = v }(*prevVersion)
+	*prevVersion = "20240701000000.0.0"
+	var control string
+	stderr := captureStderr(t, func() {
+		control = debFiles(t, packageForTest(t, "deb"))["control/control"]
+	})
+	if !strings.Contains(control, "\nVersion: 1:"+semVerTag+"\n") {
+		t.Errorf("deb control has no epoch 1:\n%s", control)
+	}
+	if !strings.Contains(stderr, "bumping the epoch to 1") {
+		t.Errorf("no epoch bump warning: %q", stderr)
+	}
+}
+
+func TestWriteChecksumsIndex(t *testing.T) {
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*releaseDir = t.TempDir()
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	files := map[string]string{
+		"linux-amd64/minio." + release:                   "#!/bin/sh\n",
+		"linux-amd64/minio." + release + *checksumSuffix: "skipped",
+		"linux-amd64/minio_20240601000000.0.0_amd64.deb": "deb",
+		"linux-amd64/SHA256SUMS":                         "skipped",
+		"downloads-minio.json":                           "{}",
+		"checksums-index.json":                           "stale",
+	}
+	for name, contents := range files {
+		p := filepath.Join(*releaseDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The digest of a built package is reused rather than recomputed.
+	built := []builtPackage{{
+		Path:   filepath.Join(*releaseDir, "linux-amd64", "minio_20240601000000.0.0_amd64.deb"),
+		SHA256: "built",
+	}}
+	if err := writeChecksumsIndex(built); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(filepath.Join(*releaseDir, "checksums-index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err = jsoniter.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	want := map[string]string{
+		"linux-amd64/minio." + release:                   sum("#!/bin/sh\n"),
+		"linux-amd64/minio_20240601000000.0.0_amd64.deb": "built",
+		"downloads-minio.json":                           sum("{}"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checksums-index.json = %v, want %v", got, want)
+	}
+}
+
+func TestSignedAPK(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(k string) { *apkSignKey = k }(*apkSignKey)
+	*apkSignKey = filepath.Join(t.TempDir(), "key.pem")
+	if err = os.WriteFile(*apkSignKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := loadAPKPublicKey(*apkSignKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgPath := packageForTest(t, "apk")
+	buf, err := os.ReadFile(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The apk is the signature, control and data gzip streams, the
+	// signature covers the control stream.
+	r := bytes.NewReader(buf)
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	var names []string
+	var sig []byte
+	tr := tar.NewReader(zr)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, th.Name)
+		if sig, err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = io.Copy(io.Discard, zr); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".SIGN.RSA." + pub.name}; !slices.Equal(names, want) {
+		t.Fatalf("signature stream %q, want %q", names, want)
+	}
+	start := len(buf) - r.Len()
+	if err = zr.Reset(r); err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	if _, err = io.Copy(io.Discard, zr); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha1.Sum(buf[start : len(buf)-r.Len()])
+	if err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, digest[:], sig); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+
+	// The public key is written next to the apk, named as in the
+	// signature.
+	if got, err := os.ReadFile(filepath.Join(filepath.Dir(pkgPath), pub.name)); err != nil || !bytes.Equal(got, pub.pem) {
+		t.Errorf("public key %s next to the apk: %v", pub.name, err)
+	}
+}
+
+func TestCompressionZstd(t *testing.T) {
+	defer func(c string) { *compression = c }(*compression)
+
+	// Varied log-like lines, repeated with edits further apart than the
+	// 32KiB gzip window, zstd matches across the repetitions.
+	var block strings.Builder
+	for i := 0; i < 4000; i++ {
+		fmt.Fprintf(&block, "%02d:%02d:%02d request=%d bucket=bucket-%d object=photos/%d/%d.jpg status=%d\n",
+			i/3600%24, i/60%60, i%60, i*7919%100003, i%17, i%101, i*31%997, []int{200, 204, 404, 503}[i%7%4])
+	}
+	var content strings.Builder
+	content.WriteString("#!/bin/sh\n")
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&content, "# section %d\n%s", i, block.String())
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte(content.String()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pkger := range []string{"deb", "rpm"} {
+		sizes := make(map[string]int64)
+		for _, algo := range []string{"gzip", "zstd"} {
+			*compression = algo
+			built := packageBinary(t, pkger, bin, filepath.Join(dir, pkger+"-"+algo))
+			sizes[algo] = built[0].Size
+		}
+		if sizes["zstd"] >= sizes["gzip"] {
+			t.Errorf("%s: zstd package %d bytes is not smaller than gzip %d bytes", pkger, sizes["zstd"], sizes["gzip"])
+		}
+	}
+}
+
+func TestInstallName(t *testing.T) {
+	defer func(n string, v bool) { *installName, *versionedInstall = n, v }(*installName, *versionedInstall)
+	*installName = "minio-server"
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	testCases := []struct {
+		versioned bool
+		want      []string
+	}{
+		{false, []string{"/usr/local/bin/minio-server"}},
+		{true, []string{"/usr/local/bin/minio-server", "/usr/local/bin/minio-server-" + release}},
+	}
+	for _, tc := range testCases {
+		*versionedInstall = tc.versioned
+		var got []string
+		for name := range debHeaders(t, packageForTest(t, "deb")) {
+			if strings.HasPrefix(name, "/usr/local/bin/") {
+				got = append(got, name)
+			}
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("versioned %v: installed %q, want %q", tc.versioned, got, tc.want)
+		}
+	}
+}
+
+func TestSkipOptionalArch(t *testing.T) {
+	defer func(d, s string) { *releaseDir, *serviceFile = d, s }(*releaseDir, *serviceFile)
+	defer func() { skippedArches = map[string]bool{} }()
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	dir := t.TempDir()
+	*releaseDir = filepath.Join(dir, "out")
+	*serviceFile = filepath.Join(dir, "minio.service")
+	if err := os.WriteFile(*serviceFile, []byte("[Unit]\nDescription=MinIO\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeBinary := func(arch string) {
+		bin := platformBinary("minio", release, "linux", arch)
+		if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeBinary("arm64")
+	writeBinary("ppc64le")
+
+	// A missing required arch still fails.
+	if _, err := doPackage("minio", release, "deb"); err == nil {
+		t.Error("packaged without the amd64 binary")
+	}
+
+	writeBinary("amd64")
+	var built []builtPackage
+	var err error
+	stderr := captureStderr(t, func() {
+		built, err = doPackage("minio", release, "deb")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var arches []string
+	for _, b := range built {
+		arches = append(arches, b.Arch)
+	}
+	sort.Strings(arches)
+	if want := []string{"amd64", "arm64", "ppc64le"}; !slices.Equal(arches, want) {
+		t.Errorf("built %q, want %q", arches, want)
+	}
+	if !strings.Contains(stderr, "skipping s390x") {
+		t.Errorf("no warning s390x is skipped: %q", stderr)
+	}
+
+	for name, d := range map[string]any{
+		"community": generateDownloadsJSON("20240601000000.0.0", "minio"),
+		"combined":  generateCombinedDownloadsJSON("20240601000000.0.0", "minio"),
+	} {
+		dropSkippedArches(d)
+		for _, dj := range allDownloads(d) {
+			for platform, products := range dj.platforms() {
+				for product, arches := range products {
+					if _, ok := arches["s390x"]; ok {
+						t.Errorf("%s: %s %s lists the skipped s390x", name, platform, product)
+					}
+				}
+			}
+		}
+		if _, ok := allDownloads(d)[0].Linux["MinIO Server"]["ppc64le"]; !ok {
+			t.Errorf("%s: downloads JSON does not list ppc64le", name)
+		}
+	}
+}
+
+func TestWriteCASStore(t *testing.T) {
+	built := buildForTest(t, "deb,apk")
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*releaseDir = filepath.Dir(filepath.Dir(built[0].Path))
+
+	cas := filepath.Join(t.TempDir(), "cas")
+	if err := writeCASStore(cas, built); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range built {
+		obj := filepath.Join(cas, b.SHA256)
+		if sum, err := sha256File(obj); err != nil || sum != b.SHA256 {
+			t.Errorf("%s: object %s has sha256 %s: %v", b.Packager, obj, sum, err)
+		}
+		buf, err := os.ReadFile(obj + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got casObject
+		if err = jsoniter.Unmarshal(buf, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := casObject{
+			Name:     filepath.Base(b.Path),
+			Path:     "linux-amd64/" + filepath.Base(b.Path),
+			Packager: b.Packager,
+			Arch:     "amd64",
+			Size:     b.Size,
+		}
+		if got != want {
+			t.Errorf("%s: %s.json = %+v, want %+v", b.Packager, b.SHA256, got, want)
+		}
+	}
+
+	// Objects already in the store are not copied again.
+	obj := filepath.Join(cas, built[0].SHA256)
+	if err := os.WriteFile(obj, []byte("stored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCASStore(cas, built); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := os.ReadFile(obj); string(buf) != "stored" {
+		t.Errorf("object %s was copied again", obj)
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	defer func(c string, l int) { *compression, *compressionLevel = c, l }(*compression, *compressionLevel)
+
+	var content strings.Builder
+	content.WriteString("#!/bin/sh\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&content, "request=%d bucket=bucket-%d object=photos/%d/%d.jpg status=%d\n",
+			i*7919%100003, i%17, i%101, i*31%997, []int{200, 204, 404, 503}[i%7%4])
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte(content.String()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []string{"gzip", "zstd"} {
+		*compression = algo
+		sizes := make(map[int]int64)
+		for _, level := range []int{1, compressionLevels[algo][1]} {
+			*compressionLevel = level
+			built := packageBinary(t, "rpm", bin, filepath.Join(dir, fmt.Sprintf("%s-%d", algo, level)))
+			sizes[level] = built[0].Size
+		}
+		if best := compressionLevels[algo][1]; sizes[best] >= sizes[1] {
+			t.Errorf("%s: level %d rpm %d bytes is not smaller than level 1 %d bytes", algo, best, sizes[best], sizes[1])
+		}
+	}
+
+	// Invalid levels fail before any package is built, the rpm comes
+	// after the deb and apk.
+	testCases := []struct {
+		packager string
+		algo     string
+		level    int
+	}{
+		{"deb,apk,rpm", "gzip", 10},
+		{"deb,apk,rpm", "zstd", 23},
+		{"deb,apk,rpm", "xz", 5},
+		// Only rpm payloads take a level.
+		{"deb,apk", "gzip", 5},
+	}
+	for i, tc := range testCases {
+		*compression, *compressionLevel = tc.algo, tc.level
+		out := filepath.Join(dir, "invalid-"+strconv.Itoa(i))
+		if _, err := tryPackageBinary(t, tc.packager, bin, out); err == nil {
+			t.Errorf("%s: %s level %d accepted", tc.packager, tc.algo, tc.level)
+		}
+		filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				t.Errorf("%s: %s level %d: %s built before failing", tc.packager, tc.algo, tc.level, p)
+			}
+			return nil
+		})
+	}
+}
+
+func TestUniversalMacOS(t *testing.T) {
+	defer func(d string) { *releaseDir = d }(*releaseDir)
+	*releaseDir = t.TempDir()
+
+	const release = "RELEASE.2024-06-01T00-00-00Z"
+	for _, arch := range []string{"amd64", "arm64"} {
+		bin := platformBinary("minio", release, "darwin", arch)
+		if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bin, []byte(arch+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	universal := platformBinary("minio", release, "darwin", "universal")
+
+	// Without lipo nothing is merged and no universal entry is listed.
+	t.Setenv("PATH", t.TempDir())
+	var ok bool
+	var err error
+	stderr := captureStderr(t, func() {
+		ok, err = buildUniversalMacOS("minio", release)
+	})
+	if ok || err != nil {
+		t.Errorf("without lipo: universal %v, err %v, want false, nil", ok, err)
+	}
+	if !strings.Contains(stderr, "lipo not found") {
+		t.Errorf("no warning lipo is missing: %q", stderr)
+	}
+	if _, err = os.Stat(universal); err == nil {
+		t.Errorf("%s written without lipo", universal)
+	}
+
+	// lipo concatenates the binaries to -output.
+	bin := t.TempDir()
+	lipo := "#!/bin/sh\n[ \"$1 $2\" = \"-create -output\" ] || exit 1\ndst=$3\nshift 3\ncat \"$@\" >\"$dst\"\n"
+	if err = os.WriteFile(filepath.Join(bin, "lipo"), []byte(lipo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":/usr/bin:/bin")
+	if ok, err = buildUniversalMacOS("minio", release); !ok || err != nil {
+		t.Fatalf("with lipo: universal %v, err %v, want true, nil", ok, err)
+	}
+	if buf, _ := os.ReadFile(universal); string(buf) != "amd64\narm64\n" {
+		t.Errorf("universal binary = %q", buf)
+	}
+	sum, err := sha256File(universal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := os.ReadFile(universal + *checksumSuffix); string(buf) != sum+"  minio" {
+		t.Errorf("universal checksum = %q, want %q", buf, sum+"  minio")
+	}
+
+	d := generateDownloadsJSON("20240601000000.0.0", "minio")
+	arm := d.MacOS["MinIO Server"]["arm64"]
+	arm.Homebrew = &dlInfo{Text: "brew install minio/stable/minio"}
+	arm.Note = "Apple silicon only"
+	d.MacOS["MinIO Server"]["arm64"] = arm
+	addUniversalMacOS(d)
+	got, ok := d.MacOS["MinIO Server"]["universal"]
+	if !ok {
+		t.Fatal("no universal macOS entry")
+	}
+	if got.Homebrew != nil || got.Note != "" || got.RPM != nil || got.Deb != nil {
+		t.Errorf("universal entry %+v carries more than the binary", got)
+	}
+	if got.Bin == nil || got.Bin.Download != "https://dl.min.io/server/minio/release/darwin-universal/minio" {
+		t.Errorf("universal binary %+v does not point at darwin-universal", got.Bin)
+	}
+	if d.MacOS["MinIO Server"]["arm64"].Bin.Download != "https://dl.min.io/server/minio/release/darwin-arm64/minio" {
+		t.Errorf("arm64 binary %s changed", d.MacOS["MinIO Server"]["arm64"].Bin.Download)
+	}
+}
+
+func TestJSONLayoutArchRejectedUpfront(t *testing.T) {
+	testCases := []struct {
+		app  string
+		args []string
+	}{
+		{"minio", []string{"--combined"}},
+		{"minio-enterprise", nil},
+	}
+	for _, tc := range testCases {
+		dir := releaseTree(t, "deb")
+		manifest := filepath.Join(dir, "manifest.yaml")
+		buf, err := os.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(manifest, bytes.Replace(buf, []byte("app: minio\n"), []byte("app: "+tc.app+"\n"), 1), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		args := append([]string{"--json-layout", "arch", "--text-instructions", "--latest-json", "--checksums-json"}, tc.args...)
+		out, err := runPkger(t, dir, args...)
+		if err == nil {
+			t.Errorf("%s %q accepted\n%s", tc.app, tc.args, out)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "out")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s %q wrote output before failing: %v", tc.app, tc.args, err)
+		}
+	}
+}
+
+func TestArchLinuxPreRemove(t *testing.T) {
+	f, err := os.Open(packageForTest(t, "archlinux"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var install []byte
+	tr := tar.NewReader(zr)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if th.Name == ".INSTALL" {
+			if install, err = io.ReadAll(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if install == nil {
+		t.Fatal("archlinux package has no .INSTALL")
+	}
+	// pacman runs the .INSTALL functions with bash.
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+
+	// systemctl logs its arguments, the systemd check looks at a
+	// directory of the test.
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err = os.MkdirAll(filepath.Join(dir, "run", "systemd", "system"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "log")
+	if err = os.WriteFile(filepath.Join(bin, "systemctl"), []byte("#!/bin/sh\necho \"$@\" >>"+log+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "install")
+	install = bytes.ReplaceAll(install, []byte("/run/systemd/system"), []byte(filepath.Join(dir, "run", "systemd", "system")))
+	if err = os.WriteFile(script, install, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// pacman passes the version of the removed package.
+	cmd := exec.Command(bash, "-c", ". "+script+" && pre_remove 20240601000000.0.0-1")
+	cmd.Env = []string{"PATH=" + bin}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("pre_remove: %v: %s", err, out)
+	}
+	if buf, _ := os.ReadFile(log); string(buf) != "disable --now minio.service\n" {
+		t.Errorf("pre_remove ran systemctl %q, want disable --now minio.service", buf)
+	}
+}
+
+func TestCompressionRejectedUpfront(t *testing.T) {
+	defer func(c, d string) { *compression, *debCompression = c, d }(*compression, *debCompression)
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "minio")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		packager       string
+		compression    string
+		debCompression string
+	}{
+		// rpm would be built before deb rejects lzma.
+		{"rpm,deb", "lzma", ""},
+		{"deb,rpm", "none", ""},
+		{"rpm,deb", "gzip", "lzma"},
+	}
+	for i, tc := range testCases {
+		*compression, *debCompression = tc.compression, tc.debCompression
+		out := filepath.Join(dir, strconv.Itoa(i))
+		if _, err := tryPackageBinary(t, tc.packager, bin, out); err == nil {
+			t.Errorf("%s: compression %q, deb compression %q accepted", tc.packager, tc.compression, tc.debCompression)
+		}
+		filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				t.Errorf("%s: compression %q: %s built before failing", tc.packager, tc.compression, p)
+			}
+			return nil
+		})
+	}
+}